@@ -3,12 +3,14 @@ package client
 import (
 	"backup-x/entity"
 	"backup-x/util"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
-	"runtime"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,6 +18,12 @@ import (
 
 const minFileSize = 1000
 
+// Environment variables the password and the S3 secret key are passed to commands in
+const (
+	pwdEnv       = "BACKUP_X_PWD"
+	secretKeyEnv = "BACKUP_X_S3_SECRET_KEY"
+)
+
 // backupLooper handles backup loops
 type backupLooper struct {
 	Wg      sync.WaitGroup
@@ -92,126 +100,187 @@ func RunOnce() {
 	}
 }
 
-// RunByIdx runs a backup for a specific index
-func RunByIdx(idx int) {
+// RunByID runs a backup of the project with the given id
+func RunByID(id string) {
 	conf, err := entity.GetConfigCache()
 	if err != nil {
 		return
 	}
 
-	run(conf, conf.BackupConfig[idx])
+	backupConf, ok := conf.GetProjectByID(id)
+	if !ok {
+		log.Println("Project not found: " + id)
+		return
+	}
+	run(conf, backupConf)
 }
 
 // run executes a backup task
 func run(conf entity.Config, backupConf entity.BackupConfig) {
 	if backupConf.NotEmptyProject() && backupConf.Enabled == 0 {
-		err := prepare(backupConf)
+		err := prepare(conf.GetProjectPath(backupConf))
 		if err != nil {
 			log.Println(err)
 			return
 		}
 
-		// Perform backup
-		outFileName, err := backup(backupConf, conf.EncryptKey, conf.S3Config)
-		result := entity.BackupResult{ProjectName: backupConf.ProjectName, Result: "Failed"}
-		if err == nil {
-			// Webhook
-			if outFileName != nil {
-				result.FileName = outFileName.Name()
-				result.FileSize = fmt.Sprintf("%d MB", outFileName.Size()/1000/1000)
-				// Upload to S3 if configured
-				if conf.S3Config.CheckNotEmpty() {
-					go conf.S3Config.UploadFile(backupConf.GetProjectPath() + string(os.PathSeparator) + outFileName.Name())
+		result := entity.BackupResult{ProjectName: backupConf.ProjectName, Result: "Failed", RunID: newRunID(), StartTime: time.Now()}
+		result.Upload.Result = "Skipped"
+		var uploadQueued time.Duration
+
+		// A failed pre-hook skips the backup
+		if runHook(entity.HookPre, backupConf, conf, &result) == nil {
+			// Perform backup
+			var outFileName os.FileInfo
+			attempts, err := retry(backupConf.ProjectName, entity.StepBackup, backupConf.BackupRetry, func(attempt int) (err error) {
+				outFileName, err = backup(backupConf, conf, result, attempt)
+				return err
+			})
+			result.Attempts = attempts
+			if err == nil {
+				result.Result = "Success"
+				// Webhook
+				if outFileName != nil {
+					result.FileName = outFileName.Name()
+					result.FilePath = filepath.Join(conf.GetProjectPath(backupConf), outFileName.Name())
+					result.FileSize = fmt.Sprintf("%d MB", outFileName.Size()/1000/1000)
+					// Upload to the storage target of the project if configured
+					if s3Config, toReplicas, ok := conf.GetUploadTarget(backupConf); ok {
+						if uploadQueued = uploadDelay(conf, backupConf); uploadQueued > 0 {
+							result.Upload.Result = "Queued"
+						} else {
+							upload(conf, backupConf, s3Config, toReplicas, &result, result.FilePath)
+						}
+					}
 				}
+			} else {
+				result.Err = err.Error()
 			}
-			result.Result = "Success"
+		} else {
+			result.Err = "pre-hook failed"
+			log.Printf("Backup of project %s was skipped because the pre-hook failed\n", backupConf.ProjectName)
 		}
+
+		if result.Result == "Success" {
+			runHook(entity.HookPostSuccess, backupConf, conf, &result)
+		} else {
+			runHook(entity.HookPostFailure, backupConf, conf, &result)
+		}
+		runHook(entity.HookCleanup, backupConf, conf, &result)
+
+		result.Duration = time.Since(result.StartTime)
 		conf.ExecWebhook(result)
+
+		if uploadQueued > 0 {
+			go queueUpload(conf, backupConf, result, uploadQueued)
+		}
 	}
 }
 
-// prepare creates project folder
-func prepare(backupConf entity.BackupConfig) (err error) {
-	os.MkdirAll(backupConf.GetProjectPath(), 0750)
-	return
+// uploadQueues serializes the queued uploads of each storage target, keyed by endpoint and bucket
+var uploadQueues sync.Map
+
+// uploadDelay returns how long the upload of the project waits for an upload window to open
+func uploadDelay(conf entity.Config, backupConf entity.BackupConfig) time.Duration {
+	windows, err := util.ParseTimeWindows(backupConf.GetUploadWindows(conf.S3Config))
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	return util.DelayUntilTimeWindow(windows, time.Now())
 }
 
-// backup executes the backup shell command
-func backup(backupConf entity.BackupConfig, encryptKey string, s3Conf entity.S3Config) (outFileName os.FileInfo, err error) {
-	projectName := backupConf.ProjectName
-	log.Printf("Backing up project: %s ...", projectName)
+// queueUpload uploads the backup file of a finished run once the upload window opens and sends the webhook
+// again with the upload result, so the run and the other projects do not wait. Queued uploads of a target run one after another
+func queueUpload(conf entity.Config, backupConf entity.BackupConfig, result entity.BackupResult, delay time.Duration) {
+	log.Printf("Upload of %s is queued until the upload window opens in %.1f hours\n", result.FilePath, delay.Hours())
+	time.Sleep(delay)
 
-	todayString := time.Now().Format(util.FileNameFormatStr)
-	shellString := strings.ReplaceAll(backupConf.Command, "#{DATE}", todayString)
+	s3Config, toReplicas, ok := conf.GetUploadTarget(backupConf)
+	if !ok {
+		return
+	}
+	queue, _ := uploadQueues.LoadOrStore(s3Config.Endpoint+"/"+s3Config.BucketName, &sync.Mutex{})
+	queue.(*sync.Mutex).Lock()
+	defer queue.(*sync.Mutex).Unlock()
+
+	result.Upload = entity.UploadResult{}
+	upload(conf, backupConf, s3Config, toReplicas, &result, result.FilePath)
+	result.Duration = time.Since(result.StartTime)
+	conf.ExecWebhook(result)
+}
 
-	// Decrypt password
-	pwd := ""
-	if backupConf.Pwd != "" {
-		pwd, err = util.DecryptByEncryptKey(encryptKey, backupConf.Pwd)
-		if err != nil {
-			err = fmt.Errorf("decryption failed")
-			log.Println(err)
-			return nil, err
+// upload uploads the backup file to S3, retrying according to the project's policy, and copies it to the replica targets if asked.
+// The object key is the prefix of the project and the file name, whatever the local directory
+func upload(conf entity.Config, backupConf entity.BackupConfig, s3Config entity.S3Config, toReplicas bool, result *entity.BackupResult, filePath string) {
+	key := backupConf.GetS3Prefix() + filepath.Base(filePath)
+
+	attempts, err := retry(backupConf.ProjectName, entity.StepUpload, backupConf.UploadRetry, func(attempt int) (err error) {
+		result.Upload, err = s3Config.UploadFile(filePath, key)
+		return err
+	})
+	result.Attempts = append(result.Attempts, attempts...)
+	if err != nil {
+		if backupConf.UploadRequired == 1 {
+			result.Result = "Failed"
+			result.Err = err.Error()
 		}
+		return
 	}
 
-	// Decrypt S3 secret key
-	secretKey := ""
-	if s3Conf.SecretKey != "" {
-		secretKey, err = util.DecryptByEncryptKey(encryptKey, s3Conf.SecretKey)
-		if err != nil {
-			err = fmt.Errorf("decryption failed")
-			log.Println(err)
-			return nil, err
+	if toReplicas {
+		replicate(conf, backupConf, result, key)
+	}
+}
+
+// replicate copies the uploaded backup from the primary target to every replica target
+func replicate(conf entity.Config, backupConf entity.BackupConfig, result *entity.BackupResult, key string) {
+	primary := conf.S3Config.ForProject(backupConf)
+	for _, replica := range conf.Replicas {
+		if !replica.CheckNotEmpty() {
+			continue
+		}
+		replicaResult := entity.ReplicaResult{Name: replica.Name, Result: "Success"}
+		if err := replica.ForProject(backupConf).Replicate(primary, key); err != nil {
+			log.Printf("Failed to replicate %s to %s, ERR: %s\n", key, replica.Name, err)
+			replicaResult.Result = "Failed"
+			replicaResult.Err = err.Error()
 		}
+		result.Replicas = append(result.Replicas, replicaResult)
 	}
+}
 
-	// Replace placeholders
-	shellString = strings.ReplaceAll(shellString, "#{PWD}", pwd)
-	shellString = strings.ReplaceAll(shellString, "#{AccessKey}", s3Conf.AccessKey)
-	shellString = strings.ReplaceAll(shellString, "#{SecretKey}", secretKey)
-	shellString = strings.ReplaceAll(shellString, "#{Endpoint}", s3Conf.Endpoint)
-	shellString = strings.ReplaceAll(shellString, "#{BucketName}", s3Conf.BucketName)
+// newRunID returns a unique id for a run, e.g. 20220101020304-1a2b3c4d
+func newRunID() string {
+	random := make([]byte, 4)
+	rand.Read(random)
+	return time.Now().Format("20060102150405") + "-" + hex.EncodeToString(random)
+}
 
-	// Create shell file
-	var shellName string
-	if runtime.GOOS == "windows" {
-		shellName = time.Now().Format("shell-"+util.FileNameFormatStr+"-") + "backup.bat"
-	} else {
-		shellString = strings.ReplaceAll(shellString, "\r\n", "\n") // convert windows line endings
-		shellName = time.Now().Format("shell-"+util.FileNameFormatStr+"-") + "backup.sh"
-	}
+// prepare creates project folder
+func prepare(projectPath string) (err error) {
+	os.MkdirAll(projectPath, 0750)
+	return
+}
 
-	shellFile, err := os.Create(backupConf.GetProjectPath() + string(os.PathSeparator) + shellName)
-	shellFile.Chmod(0700)
-	if err == nil {
-		shellFile.WriteString(shellString)
-		shellFile.Close()
-	} else {
-		log.Println("Error creating shell file: ", err)
-	}
+// backup executes the backup shell command
+func backup(backupConf entity.BackupConfig, conf entity.Config, result entity.BackupResult, attempt int) (outFileName os.FileInfo, err error) {
+	projectName := backupConf.ProjectName
+	log.Printf("Backing up project: %s ...", projectName)
 
-	// Execute shell
-	var shell *exec.Cmd
-	if runtime.GOOS == "windows" {
-		shell = exec.Command("cmd", "/c", shellName)
-	} else {
-		shell = exec.Command("bash", shellName)
-	}
-	shell.Dir = backupConf.GetProjectPath()
-	outputBytes, err := shell.CombinedOutput()
-	if len(outputBytes) > 0 {
-		if util.IsGBK(outputBytes) {
-			outputBytes, _ = util.GbkToUtf8(outputBytes)
-		}
-		log.Printf("<span style='color: #7983f5;font-weight: bold;'>%s</span> Shell output: <span class='click-layer' onclick='showLayer(this)' tip=\"%s\" style='cursor: pointer; color: #4a3a3a; font-weight: bold; border: 2px dashed;'>Click to view</span>\n", backupConf.ProjectName, util.EscapeShell(string(outputBytes)))
-	} else {
-		log.Printf("Shell output is empty\n")
+	todayString := time.Now().Format(util.FileNameFormatStr)
+	result.Duration = time.Since(result.StartTime)
+	shellString, env, err := renderCommand(backupConf.Command, result.TemplateContext(), todayString, attempt, backupConf, conf)
+	if err != nil {
+		return nil, err
 	}
 
+	projectPath := conf.GetProjectPath(backupConf)
+	outputBytes, err := runShell(projectPath, "backup", projectName, shellString, env)
+
 	// Check if backup was successful
 	if err == nil {
-		outFileName, err = findBackupFile(backupConf, todayString)
+		outFileName, err = findBackupFile(backupConf, projectPath, todayString)
 		if backupConf.BackupType == 0 {
 			// Database backup
 			if err != nil {
@@ -231,15 +300,75 @@ func backup(backupConf entity.BackupConfig, encryptKey string, s3Conf entity.S3C
 		log.Println(err)
 	}
 
-	// Remove shell file
-	os.Remove(shellFile.Name())
-
 	return
 }
 
-// findBackupFile searches for backup file containing today's date
-func findBackupFile(backupConf entity.BackupConfig, todayString string) (backupFile os.FileInfo, err error) {
-	files, err := ioutil.ReadDir(backupConf.GetProjectPath())
+// renderCommand expands the placeholders of a command with the run context, the date, the attempt,
+// the password, the object storage settings and the secrets. Values are quoted as one shell argument unless the placeholder
+// ends with |raw; the placeholders of older versions (#{DATE}, #{AccessKey}, #{Endpoint}, #{BucketName}) are inserted as they are.
+// Secrets, the password and the S3 secret key are passed in the returned environment variables and only referenced from the command
+func renderCommand(command string, ctx util.TemplateContext, todayString string, attempt int, backupConf entity.BackupConfig, conf entity.Config) (shellString string, env []string, err error) {
+	s3Conf := conf.S3Config
+
+	// Commands of older versions may still have a reference inside quotes, which would end the quoting
+	if ref := entity.QuotedReference(command); ref != "" {
+		err = fmt.Errorf("%s expands to a quoted value itself, move it out of the quotes", ref)
+		log.Println(err)
+		return "", nil, err
+	}
+
+	// Decrypt password
+	pwd := ""
+	if backupConf.Pwd != "" {
+		pwd, err = conf.ResolveSecret(backupConf.Pwd)
+		if err != nil {
+			err = fmt.Errorf("resolving password failed: %s", err)
+			log.Println(err)
+			return "", nil, err
+		}
+	}
+
+	// Decrypt S3 secret key
+	secretKey := ""
+	if s3Conf.SecretKey != "" {
+		secretKey, err = conf.ResolveSecret(s3Conf.SecretKey)
+		if err != nil {
+			err = fmt.Errorf("resolving S3 secret key failed: %s", err)
+			log.Println(err)
+			return "", nil, err
+		}
+	}
+
+	// Reference secrets instead of writing them into the command
+	ctx.Refs, env, err = conf.GetSecretEnv(command)
+	if err != nil {
+		log.Println(err)
+		return "", nil, err
+	}
+
+	// Pass the password and S3 secret key in environment variables as well
+	ctx.Refs["PWD"] = entity.EnvReference(pwdEnv)
+	ctx.Refs["SecretKey"] = entity.EnvReference(secretKeyEnv)
+	env = append(env, pwdEnv+"="+pwd, secretKeyEnv+"="+secretKey)
+
+	// Replace placeholders, values of the run like the error output may hold anything and are shell quoted
+	ctx.Refs["DATE"] = todayString
+	ctx.Refs["AccessKey"] = s3Conf.AccessKey
+	ctx.Refs["Endpoint"] = s3Conf.Endpoint
+	ctx.Refs["BucketName"] = s3Conf.BucketName
+	ctx.Values["attempt"] = strconv.Itoa(attempt)
+	shellString = ctx.Expand(command, util.EscapeModeShell)
+	if i := strings.Index(shellString, "#{secret:"); i >= 0 {
+		err = fmt.Errorf("unknown secret in command: %s", strings.SplitN(shellString[i:], "}", 2)[0]+"}")
+		log.Println(err)
+		return "", nil, err
+	}
+	return shellString, env, nil
+}
+
+// findBackupFile searches the project folder for backup file containing today's date
+func findBackupFile(backupConf entity.BackupConfig, projectPath string, todayString string) (backupFile os.FileInfo, err error) {
+	files, err := ioutil.ReadDir(projectPath)
 	for _, file := range files {
 		if strings.Contains(file.Name(), todayString) && !strings.HasPrefix(file.Name(), "shell-") {
 			backupFile = file
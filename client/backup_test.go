@@ -0,0 +1,52 @@
+package client
+
+import (
+	"backup-x/entity"
+	"backup-x/util"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRenderCommandQuotesValues(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command is run by bash")
+	}
+	dir := t.TempDir()
+	ctx := util.TemplateContext{Values: map[string]string{"error": `"; touch x; echo "`}}
+	conf := entity.Config{}
+	conf.S3Config.BucketName = "bucket"
+
+	shellString, env, err := renderCommand("echo #{error} #{DATE} #{BucketName} #{error|raw}x", ctx, "2022-01-02", 1, entity.BackupConfig{}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(shellString, `echo '"; touch x; echo "' 2022-01-02 bucket "; touch x`) {
+		t.Errorf("Command not rendered correctly: %s", shellString)
+	}
+
+	shellString, env, _ = renderCommand("echo #{error}", ctx, "2022-01-02", 1, entity.BackupConfig{}, conf)
+	output, err := runShell(dir, "backup", "test", shellString, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(output)) != `"; touch x; echo "` {
+		t.Errorf("Value not passed as one argument: %s", output)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x")); !os.IsNotExist(err) {
+		t.Error("A value of the run context must not be executed")
+	}
+}
+
+func TestRenderCommandRejectsQuotedReferences(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("single quotes are no quotes for cmd")
+	}
+	for _, command := range []string{"mysqldump '-p#{PWD}' db", `mysqldump "-p#{PWD}" db`} {
+		if _, _, err := renderCommand(command, util.TemplateContext{}, "2022-01-02", 1, entity.BackupConfig{}, entity.Config{}); err == nil {
+			t.Errorf("A reference inside quotes must fail the run: %s", command)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"backup-x/util"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -26,17 +28,34 @@ func DeleteOldBackup() {
 				continue
 			}
 			// Delete old local files
-			deleteLocalOlderFiles(backupConf)
+			deleteLocalOlderFiles(conf.GetProjectPath(backupConf), backupConf)
 
 			// Delete old files from object storage (S3)
 			deleteS3OlderFiles(conf.S3Config, backupConf)
+
+			// Delete old files from replica targets with their own retention
+			for _, replica := range conf.Replicas {
+				replicaConf := backupConf
+				replicaConf.SaveDaysS3 = replica.GetSaveDays(backupConf)
+				deleteS3OlderFiles(replica.S3Config, replicaConf)
+			}
+		}
+
+		// Abort multipart uploads that can no longer be resumed, once per target
+		if conf.S3Config.CheckNotEmpty() {
+			conf.S3Config.AbortAbandonedUploads()
+		}
+		for _, replica := range conf.Replicas {
+			if replica.S3Config.CheckNotEmpty() {
+				replica.S3Config.AbortAbandonedUploads()
+			}
 		}
 	}
 }
 
-// deleteLocalOlderFiles deletes expired local backup files
-func deleteLocalOlderFiles(backupConf entity.BackupConfig) {
-	backupFiles, err := os.ReadDir(backupConf.GetProjectPath())
+// deleteLocalOlderFiles deletes expired local backup files in the project folder projectPath
+func deleteLocalOlderFiles(projectPath string, backupConf entity.BackupConfig) {
+	backupFiles, err := os.ReadDir(projectPath)
 	if err != nil {
 		log.Printf("Failed to read local directory for project %s! ERR: %s\n", backupConf.ProjectName, err)
 		return
@@ -48,15 +67,16 @@ func deleteLocalOlderFiles(backupConf entity.BackupConfig) {
 
 	backupFileNames := make([]string, 0)
 	for _, backupFile := range backupFiles {
-		if !backupFile.IsDir() {
+		// Sidecar hold markers are not backups and are never deleted by retention
+		if !backupFile.IsDir() && !entity.IsHoldMarker(backupFile.Name()) {
 			info, err := backupFile.Info()
 			if err == nil {
 				if info.Size() >= minFileSize {
 					backupFileNames = append(backupFileNames, backupFile.Name())
 				} else {
-					if util.IsFileNameDate(backupFile.Name()) {
-						log.Printf("Backup file size %d bytes is less than minimum %d, deleting file: %s", info.Size(), minFileSize, backupConf.GetProjectPath()+string(os.PathSeparator)+backupFile.Name())
-						os.Remove(backupConf.GetProjectPath() + string(os.PathSeparator) + backupFile.Name())
+					if util.IsFileNameDate(backupFile.Name()) && !entity.IsHeld(backupConf.ProjectName, backupFile.Name()) {
+						log.Printf("Backup file size %d bytes is less than minimum %d, deleting file: %s", info.Size(), minFileSize, filepath.Join(projectPath, backupFile.Name()))
+						os.Remove(filepath.Join(projectPath, backupFile.Name()))
 					}
 				}
 			}
@@ -66,11 +86,16 @@ func deleteLocalOlderFiles(backupConf entity.BackupConfig) {
 	tobeDeleteFiles := util.FileNameBeforeDays(backupConf.SaveDays, backupFileNames, backupConf.ProjectName)
 
 	for i := 0; i < len(tobeDeleteFiles); i++ {
-		err := os.Remove(backupConf.GetProjectPath() + string(os.PathSeparator) + tobeDeleteFiles[i])
+		if hold, held := entity.GetHold(backupConf.ProjectName, tobeDeleteFiles[i]); held {
+			log.Printf("Expired local file is held and will not be deleted: %s, reason: %s, owner: %s", filepath.Join(projectPath, tobeDeleteFiles[i]), hold.Reason, hold.Owner)
+			continue
+		}
+		filePath := filepath.Join(projectPath, tobeDeleteFiles[i])
+		err := os.Remove(filePath)
 		if err == nil {
-			log.Printf("Successfully deleted expired local file: %s", backupConf.ProjectName+string(os.PathSeparator)+tobeDeleteFiles[i])
+			log.Printf("Successfully deleted expired local file: %s", filePath)
 		} else {
-			log.Printf("Failed to delete expired local file: %s, ERR: %s", backupConf.ProjectName+string(os.PathSeparator)+tobeDeleteFiles[i], err)
+			log.Printf("Failed to delete expired local file: %s, ERR: %s", filePath, err)
 		}
 	}
 }
@@ -85,16 +110,42 @@ func deleteS3OlderFiles(s3Conf entity.S3Config, backupConf entity.BackupConfig)
 		return
 	}
 
-	fileNames, err := s3Conf.ListFiles(backupConf.GetProjectPath())
+	objects, err := s3Conf.ListFiles(backupConf.GetS3Prefix())
 	if err != nil {
 		log.Printf("Failed to read S3 directory for project %s! ERR: %s\n", backupConf.ProjectName, err)
 		return
 	}
 
-	tobeDeleteFiles := util.FileNameBeforeDays(backupConf.SaveDaysS3, fileNames, backupConf.ProjectName)
+	files := make([]util.DatedFile, 0, len(objects))
+	markers := make(map[string]bool)
+	for _, object := range objects {
+		if entity.IsHoldMarker(object.Key) {
+			markers[strings.TrimSuffix(object.Key, entity.HoldMarkerSuffix)] = true
+			continue
+		}
+		files = append(files, util.DatedFile{Name: object.Key, ModTime: object.LastModified})
+	}
+	tobeDeleteFiles := util.FilesBeforeDays(backupConf.SaveDaysS3, files, backupConf.ProjectName)
 
 	for i := 0; i < len(tobeDeleteFiles); i++ {
-		err := s3Conf.DeleteFile(tobeDeleteFiles[i])
+		if hold, held := entity.GetHold(backupConf.ProjectName, tobeDeleteFiles[i]); held {
+			log.Printf("Expired file in S3 is held and will not be deleted: %s, reason: %s, owner: %s", tobeDeleteFiles[i], hold.Reason, hold.Owner)
+			continue
+		}
+		if markers[tobeDeleteFiles[i]] {
+			log.Printf("Expired file in S3 is held by a marker and will not be deleted: %s", tobeDeleteFiles[i])
+			continue
+		}
+		locked, err := s3Conf.IsLocked(tobeDeleteFiles[i])
+		if err != nil {
+			log.Printf("Failed to read the Object Lock of expired file %s, it will not be deleted. ERR: %s", tobeDeleteFiles[i], err)
+			continue
+		}
+		if locked {
+			log.Printf("Expired file in S3 is locked and will not be deleted: %s", tobeDeleteFiles[i])
+			continue
+		}
+		err = s3Conf.DeleteFile(tobeDeleteFiles[i])
 		if err == nil {
 			log.Printf("Successfully deleted expired file from S3: %s", tobeDeleteFiles[i])
 		} else {
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"backup-x/entity"
+	"backup-x/util"
+	"log"
+	"strings"
+	"time"
+)
+
+// maxHookOutput limits the hook output kept in the backup result
+const maxHookOutput = 4096
+
+// runHook runs a hook of the project if it is set and records its outcome in the result
+func runHook(name string, backupConf entity.BackupConfig, conf entity.Config, result *entity.BackupResult) error {
+	command := backupConf.GetHook(name)
+	if strings.TrimSpace(command) == "" {
+		return nil
+	}
+	log.Printf("Running %s hook of project: %s ...", name, backupConf.ProjectName)
+
+	start := time.Now()
+	hookResult := entity.HookResult{Hook: name, Result: "Success"}
+	result.Duration = time.Since(result.StartTime)
+	shellString, env, err := renderCommand(command, result.TemplateContext(), start.Format(util.FileNameFormatStr), 1, backupConf, conf)
+	if err == nil {
+		var outputBytes []byte
+		outputBytes, err = runShell(conf.GetProjectPath(backupConf), name+"-hook", backupConf.ProjectName+" "+name+" hook", shellString, env)
+		if len(outputBytes) > maxHookOutput {
+			outputBytes = outputBytes[len(outputBytes)-maxHookOutput:]
+		}
+		hookResult.Output = string(outputBytes)
+	}
+	hookResult.Duration = time.Since(start)
+
+	if err != nil {
+		hookResult.Result = "Failed"
+		hookResult.Err = err.Error()
+		log.Printf("%s hook of project %s failed, ERR: %s\n", name, backupConf.ProjectName, err)
+	}
+	result.Hooks = append(result.Hooks, hookResult)
+	return err
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"backup-x/entity"
+	"log"
+	"time"
+)
+
+// retry runs fn with the attempt number until it succeeds or the policy's attempts are exhausted, recording every attempt
+func retry(projectName string, step string, policy entity.RetryPolicy, fn func(attempt int) error) (records []entity.AttemptRecord, err error) {
+	maxAttempts := policy.Attempts()
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := policy.Delay(attempt - 1)
+			log.Printf("%s %s attempt %d/%d will start in %s\n", projectName, step, attempt, maxAttempts, delay.Round(time.Second))
+			time.Sleep(delay)
+		}
+
+		start := time.Now()
+		err = fn(attempt)
+		record := entity.AttemptRecord{Step: step, Attempt: attempt, Start: start, Duration: time.Since(start)}
+		if err != nil {
+			record.Err = err.Error()
+			log.Printf("%s %s attempt %d/%d failed, ERR: %s\n", projectName, step, attempt, maxAttempts, err)
+		}
+		records = append(records, record)
+
+		if err == nil {
+			return
+		}
+	}
+	return
+}
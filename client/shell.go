@@ -0,0 +1,127 @@
+package client
+
+import (
+	"backup-x/entity"
+	"backup-x/util"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// shellFilePrefix names the scripts written to disk on Windows, which cmd cannot read from a pipe
+const shellFilePrefix = "shell-"
+
+// runShell executes the shell string in the project folder projectPath and logs its output.
+// On Unix the script is passed to bash through a pipe and never touches the disk,
+// on Windows it is written to a batch file that is removed afterwards
+func runShell(projectPath string, name string, label string, shellString string, env []string) (outputBytes []byte, err error) {
+	var shell *exec.Cmd
+	if runtime.GOOS == "windows" {
+		shellName := time.Now().Format(shellFilePrefix+util.FileNameFormatStr+"-") + name + ".bat"
+		shellPath := filepath.Join(projectPath, shellName)
+		err = os.WriteFile(shellPath, []byte(shellString), 0700)
+		if err != nil {
+			log.Println("Error creating shell file: ", err)
+			return nil, err
+		}
+		defer removeShellFile(shellPath)
+		// Delayed expansion keeps the values of the "!NAME!" references from being parsed by cmd.
+		// It changes the meaning of a literal !, so scripts without references run as before
+		if usesEnvReference(shellString, env) {
+			shell = exec.Command("cmd", "/V:ON", "/c", shellName)
+		} else {
+			shell = exec.Command("cmd", "/c", shellName)
+		}
+	} else {
+		shellString = strings.ReplaceAll(shellString, "\r\n", "\n") // convert windows line endings
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		go func() {
+			io.WriteString(writer, shellString)
+			writer.Close()
+		}()
+		// bash reads the script from the pipe on fd 3, leaving stdin to the commands
+		shell = exec.Command("bash", "/dev/fd/3")
+		shell.ExtraFiles = []*os.File{reader}
+	}
+
+	shell.Dir = projectPath
+	shell.Env = append(os.Environ(), env...)
+	outputBytes, err = shell.CombinedOutput()
+	if len(outputBytes) > 0 {
+		if util.IsGBK(outputBytes) {
+			outputBytes, _ = util.GbkToUtf8(outputBytes)
+		}
+		log.Printf("<span style='color: #7983f5;font-weight: bold;'>%s</span> Shell output: <span class='click-layer' onclick='showLayer(this)' tip=\"%s\" style='cursor: pointer; color: #4a3a3a; font-weight: bold; border: 2px dashed;'>Click to view</span>\n", label, util.EscapeShell(string(outputBytes)))
+	} else {
+		log.Printf("Shell output is empty\n")
+	}
+
+	return
+}
+
+// usesEnvReference checks whether a script references one of the environment variables "NAME=value" with entity.EnvReference
+func usesEnvReference(shellString string, env []string) bool {
+	for _, variable := range env {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if strings.Contains(shellString, entity.EnvReference(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SweepShellFiles removes scripts left in the project folders by an interrupted run,
+// including scripts of older versions that contained decrypted secrets
+func SweepShellFiles() {
+	conf, _ := entity.GetConfigCache()
+	for _, backupConf := range conf.BackupConfig {
+		if backupConf.ProjectName == "" {
+			continue
+		}
+		projectPath := conf.GetProjectPath(backupConf)
+		files, err := os.ReadDir(projectPath)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasPrefix(file.Name(), shellFilePrefix) {
+				continue
+			}
+			shellPath := filepath.Join(projectPath, file.Name())
+			if err := removeShellFile(shellPath); err != nil {
+				log.Printf("Failed to remove leftover script %s, ERR: %s\n", shellPath, err)
+			} else {
+				log.Printf("Removed leftover script %s\n", shellPath)
+			}
+		}
+	}
+}
+
+// removeShellFile overwrites a script with zeros before removing it
+func removeShellFile(shellPath string) error {
+	info, err := os.Stat(shellPath)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(shellPath, os.O_WRONLY, 0)
+	if err == nil {
+		_, err = file.Write(make([]byte, info.Size()))
+		if err == nil {
+			err = file.Sync()
+		}
+		file.Close()
+	}
+	if err != nil {
+		log.Printf("Failed to overwrite %s before removing it, ERR: %s\n", shellPath, err)
+	}
+	return os.Remove(shellPath)
+}
@@ -1,25 +1,37 @@
 package entity
 
 import (
+	"backup-x/util"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"gopkg.in/yaml.v2"
 )
 
-// parentSavePath is the main directory to store backup files
+// parentSavePath is the default directory of the backup files and the configuration, and the prefix of the object keys
 const parentSavePath = "backup-x-files"
 
+// configFilePath is the configuration file, its directory also keeps the history, holds and upload state
+var configFilePath = filepath.Join(parentSavePath, ".backup_x_config.yaml")
+
 // Config represents the YAML configuration file
 // In Go, struct field names must be capitalized to map to the lowercase keys in config.yml
 type Config struct {
 	User
 	BackupConfig []BackupConfig
+	Templates    []ProjectTemplate // User-defined project templates
+	BackupRoot   string            // Directory of the backup files, absolute or relative to the working directory, defaults to backup-x-files
 	Webhook
 	S3Config
-	EncryptKey string // Encryption key
+	Replicas   []ReplicaTarget // Secondary targets receiving a copy of every backup
+	Secrets    []Secret        // Named credentials for commands
+	Vault      VaultConfig     // Vault for vault: secret references
+	EncryptKey string          // Encryption key, empty when a master key is provided at startup
+	KeyID      string          // Identifies the master key the config is encrypted with
+	KeySalt    string          // Salt deriving the master key from a passphrase
 }
 
 // cacheType holds the cached configuration
@@ -48,6 +60,7 @@ func GetConfigCache() (conf Config, err error) {
 	_, err = os.Stat(configFilePath)
 	if err != nil {
 		log.Println("Configuration file not found! Please enter it via the web interface.")
+		cache.ConfigSingle.applyMasterKey()
 		cache.Err = err
 		return *cache.ConfigSingle, err
 	}
@@ -66,6 +79,59 @@ func GetConfigCache() (conf Config, err error) {
 		return *cache.ConfigSingle, err
 	}
 
+	// Use the master key provided at startup
+	migrated, err := cache.ConfigSingle.applyMasterKey()
+	if err != nil {
+		log.Println(err)
+		cache.Err = err
+		return *cache.ConfigSingle, err
+	}
+	if migrated && writeConfigFile(cache.ConfigSingle) == nil {
+		log.Println("Moved the encryption key out of the configuration file")
+	}
+
+	// Encrypt values of the legacy format again with a random nonce per value
+	if cache.ConfigSingle.EncryptKey != "" && cache.ConfigSingle.hasLegacyCipherText() {
+		migrated := *cache.ConfigSingle
+		if err := migrated.reencrypt(migrated.EncryptKey); err != nil {
+			log.Println("Failed to migrate encrypted values", err)
+		} else if err := writeConfigFile(&migrated); err == nil {
+			log.Println("Migrated encrypted values to the current format")
+			cache.ConfigSingle = &migrated
+		}
+	}
+
+	// Passwords and secrets expand to a quoted reference, quotes around them written for older versions are removed
+	unquoted := *cache.ConfigSingle
+	unquoted.BackupConfig = append([]BackupConfig(nil), unquoted.BackupConfig...)
+	unquoted.Templates = append([]ProjectTemplate(nil), unquoted.Templates...)
+	if unquoted.unquoteReferences() {
+		if err := writeConfigFile(&unquoted); err == nil {
+			log.Println("Removed the quotes around #{PWD}, #{SecretKey} and #{secret:NAME} in the commands")
+			cache.ConfigSingle = &unquoted
+		}
+	}
+	cache.ConfigSingle.warnQuotedReferences()
+
+	// Revisions of older versions kept the EncryptKey, or were not encrypted again when it was rotated
+	if cache.ConfigSingle.EncryptKey != "" && hasForeignKeyRevisions(cache.ConfigSingle.EncryptKey) {
+		if err := reencryptConfigHistory(cache.ConfigSingle.EncryptKey, cache.ConfigSingle.EncryptKey); err != nil {
+			log.Println("Failed to migrate the configuration history", err)
+		} else {
+			log.Println("Encrypted the configuration history under the current key")
+		}
+	}
+
+	// Projects saved before they had ids get one, empty slots of the former fixed list are removed
+	withIDs := *cache.ConfigSingle
+	withIDs.removeEmptyProjects()
+	if withIDs.EnsureProjectIDs() || len(withIDs.BackupConfig) != len(cache.ConfigSingle.BackupConfig) {
+		if err := withIDs.saveRevision("", "Assigned project ids"); err == nil {
+			log.Println("Assigned ids to the projects and removed empty ones")
+			cache.ConfigSingle = &withIDs
+		}
+	}
+
 	// Clear previous error
 	cache.Err = nil
 	return *cache.ConfigSingle, err
@@ -73,32 +139,122 @@ func GetConfigCache() (conf Config, err error) {
 
 // SaveConfig saves the configuration to file
 func (conf *Config) SaveConfig() (err error) {
+	return conf.SaveConfigBy("", "")
+}
+
+// SaveConfigBy saves the configuration to file and keeps it in the history with the author and comment
+func (conf *Config) SaveConfigBy(author string, comment string) (err error) {
+	if declarativeFile != "" {
+		return ErrConfigReadOnly
+	}
+
 	cache.Lock.Lock()
 	defer cache.Lock.Unlock()
 
-	byt, err := yaml.Marshal(conf)
-	if err != nil {
-		log.Println(err)
+	if err = conf.saveRevision(author, comment); err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(getConfigFilePath(), byt, 0600)
+	// Clear cached configuration
+	cache.ConfigSingle = nil
+
+	return
+}
+
+// saveRevision writes the configuration file and keeps it in the history, the cache must be locked
+func (conf *Config) saveRevision(author string, comment string) (err error) {
+	oldByt, err := ioutil.ReadFile(getConfigFilePath())
+	if err != nil {
+		oldByt = nil
+	}
+
+	conf.EnsureProjectIDs()
+	byt, err := marshalConfigFile(conf)
+	if err != nil {
+		return err
+	}
+	err = util.WriteFileAtomic(getConfigFilePath(), byt, 0600)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
+	if err := addConfigRevision(oldByt, byt, conf, author, comment); err != nil {
+		log.Println("Failed to keep the configuration history", err)
+	}
 
-	// Clear cached configuration
-	cache.ConfigSingle = nil
+	// Existing backups follow a project to its new directory
+	var oldConf Config
+	if oldByt != nil && yaml.Unmarshal(oldByt, &oldConf) == nil {
+		conf.moveProjectDirs(oldConf)
+	}
+	return nil
+}
 
+// writeConfigFile writes the configuration to file
+func writeConfigFile(conf *Config) (err error) {
+	byt, err := marshalConfigFile(conf)
+	if err != nil {
+		return err
+	}
+
+	err = util.WriteFileAtomic(getConfigFilePath(), byt, 0600)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 	return
 }
 
-// getConfigFilePath returns the path to the config file inside the backup directory
-func getConfigFilePath() string {
-	_, err := os.Stat(parentSavePath)
+// marshalConfigFile returns the content of the configuration file, with only the id of a master key provided at startup
+func marshalConfigFile(conf *Config) (byt []byte, err error) {
+	if HasMasterKey() {
+		fileConf := *conf
+		fileConf.KeyID = GetKeyID(conf.EncryptKey)
+		fileConf.KeySalt = getMasterKeySalt()
+		fileConf.EncryptKey = ""
+		conf = &fileConf
+	}
+
+	byt, err = yaml.Marshal(conf)
 	if err != nil {
-		os.Mkdir(parentSavePath, 0750)
+		log.Println(err)
+	}
+	return byt, err
+}
+
+// removeEmptyProjects removes the projects without a name and command, the config keeps its own list
+func (conf *Config) removeEmptyProjects() {
+	projects := make([]BackupConfig, 0, len(conf.BackupConfig))
+	for _, backupConf := range conf.BackupConfig {
+		if !backupConf.EmptyProject() {
+			projects = append(projects, backupConf)
+		}
 	}
-	return parentSavePath + string(os.PathSeparator) + ".backup_x_config.yaml"
+	conf.BackupConfig = projects
+}
+
+// deepCopySecrets copies the slices holding encrypted values so they can be changed without affecting the original
+func (conf *Config) deepCopySecrets() {
+	conf.BackupConfig = append([]BackupConfig(nil), conf.BackupConfig...)
+	conf.Replicas = append([]ReplicaTarget(nil), conf.Replicas...)
+	conf.Secrets = append([]Secret(nil), conf.Secrets...)
+}
+
+// SetConfigFilePath changes the location of the configuration file, relative paths are relative to the working directory
+func SetConfigFilePath(path string) {
+	cache.Lock.Lock()
+	defer cache.Lock.Unlock()
+	configFilePath = filepath.Clean(path)
+	cache.ConfigSingle = nil
+}
+
+// getConfigFilePath returns the path to the config file, creating its directory
+func getConfigFilePath() string {
+	os.MkdirAll(getDataPath(), 0750)
+	return configFilePath
+}
+
+// getDataPath returns the directory of the configuration file, which also keeps the history, holds and upload state
+func getDataPath() string {
+	return filepath.Dir(configFilePath)
 }
@@ -1,21 +1,131 @@
 package entity
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
 // BackupConfig represents a backup configuration
 type BackupConfig struct {
-	ProjectName string // Project name
-	Command     string // Command to run
-	SaveDays    int    // Number of days to keep local backups
-	SaveDaysS3  int    // Number of days to keep backups in object storage (S3)
-	StartTime   int    // Start time (0-23)
-	Period      int    // Interval period (minutes)
-	Pwd         string // Password
-	BackupType  int    // Backup type: 0 = Database backup, 1 = File sync
-	Enabled     int    // Whether enabled: 0 = Enabled, 1 = Disabled
+	ID              string      // Stable id of the project, kept when it is renamed or moved
+	ProjectName     string      // Project name
+	Group           string      // Group the project belongs to
+	Tags            []string    // Tags to filter and select projects
+	Command         string      // Command to run
+	SaveDays        int         // Number of days to keep local backups
+	SaveDaysS3      int         // Number of days to keep backups in object storage (S3)
+	StorageTarget   string      // Where backups are uploaded: empty = object storage and replicas, local = not uploaded, or a replica target
+	OutputDir       string      // Directory of the backup files, absolute or relative to the backup root, defaults to the project name
+	StartTime       int         // Start time (0-23)
+	Period          int         // Interval period (minutes)
+	Pwd             string      // Password
+	BackupType      int         // Backup type: 0 = Database backup, 1 = File sync
+	Enabled         int         // Whether enabled: 0 = Enabled, 1 = Disabled
+	BackupRetry     RetryPolicy // Retry policy for the backup shell
+	UploadRetry     RetryPolicy // Retry policy for the S3 upload
+	UploadRequired  int         // Whether an upload failure fails the backup: 0 = No, 1 = Yes
+	MaxUploadKBps   int         // Project upload bandwidth limit (KB/s), 0 = unlimited
+	MaxDownloadKBps int         // Project download bandwidth limit (KB/s), 0 = unlimited
+	UploadWindows   string      // Daily windows when uploads are allowed, overrides the global windows
+	PreHook         string      // Command run before the backup, a failure skips the backup
+	PostSuccessHook string      // Command run after a successful backup
+	PostFailureHook string      // Command run after a failed backup
+	CleanupHook     string      // Command always run last
+}
+
+// Hook names, in the order they run
+const (
+	HookPre         = "pre"
+	HookPostSuccess = "post-success"
+	HookPostFailure = "post-failure"
+	HookCleanup     = "cleanup"
+)
+
+// GetHook returns the command of the hook with the given name
+func (backupConfig *BackupConfig) GetHook(name string) string {
+	switch name {
+	case HookPre:
+		return backupConfig.PreHook
+	case HookPostSuccess:
+		return backupConfig.PostSuccessHook
+	case HookPostFailure:
+		return backupConfig.PostFailureHook
+	case HookCleanup:
+		return backupConfig.CleanupHook
+	}
+	return ""
+}
+
+// GetBackupRoot returns the directory of the backup files of the projects without an absolute output directory
+func (conf *Config) GetBackupRoot() string {
+	if strings.TrimSpace(conf.BackupRoot) == "" {
+		return parentSavePath
+	}
+	return filepath.Clean(conf.BackupRoot)
+}
+
+// GetProjectPath returns the directory of the backup files of a project, where its scripts run
+func (conf *Config) GetProjectPath(backupConf BackupConfig) string {
+	dir := backupConf.OutputDir
+	if strings.TrimSpace(dir) == "" {
+		dir = backupConf.ProjectName
+	}
+	if filepath.IsAbs(dir) {
+		return filepath.Clean(dir)
+	}
+	return filepath.Join(conf.GetBackupRoot(), dir)
+}
+
+// absProjectPath returns the absolute directory of a project, to compare directories written differently
+func (conf *Config) absProjectPath(backupConf BackupConfig) string {
+	projectPath := conf.GetProjectPath(backupConf)
+	if abs, err := filepath.Abs(projectPath); err == nil {
+		return abs
+	}
+	return projectPath
+}
+
+// moveProjectDirs moves the backup files of the projects whose directory changed with the backup root,
+// output directory or project name. Files that cannot be moved stay where they are and are no longer listed or cleaned up
+func (conf *Config) moveProjectDirs(oldConf Config) {
+	for _, backupConf := range conf.BackupConfig {
+		oldBackupConf, ok := oldConf.GetProjectByID(backupConf.ID)
+		if !ok {
+			continue
+		}
+		oldPath, newPath := oldConf.absProjectPath(oldBackupConf), conf.absProjectPath(backupConf)
+		if oldPath == newPath {
+			continue
+		}
+		if _, err := os.Stat(oldPath); err != nil {
+			continue
+		}
+		if _, err := os.Stat(newPath); err == nil {
+			log.Printf("Project %s now keeps its backups in %s, the existing directory is not replaced. Move the backups in %s yourself, they are no longer cleaned up\n",
+				backupConf.ProjectName, newPath, oldPath)
+			continue
+		}
+		err := os.MkdirAll(filepath.Dir(newPath), 0750)
+		if err == nil {
+			err = os.Rename(oldPath, newPath)
+		}
+		if err != nil {
+			log.Printf("Failed to move the backups of project %s from %s to %s, move them yourself, they are no longer cleaned up. ERR: %s\n",
+				backupConf.ProjectName, oldPath, newPath, err)
+		} else {
+			log.Printf("Moved the backups of project %s from %s to %s\n", backupConf.ProjectName, oldPath, newPath)
+		}
+	}
 }
 
-// GetProjectPath returns the path for the project
-func (backupConfig *BackupConfig) GetProjectPath() string {
-	return parentSavePath + "/" + backupConfig.ProjectName
+// GetS3Prefix returns the object key prefix of the project in S3, ending with "/".
+// It does not depend on the local directories and always uses forward slashes
+func (backupConfig *BackupConfig) GetS3Prefix() string {
+	return parentSavePath + "/" + backupConfig.ProjectName + "/"
 }
 
 // NotEmptyProject checks if the project is not empty
@@ -23,7 +133,51 @@ func (backupConfig *BackupConfig) NotEmptyProject() bool {
 	return backupConfig.Command != "" && backupConfig.ProjectName != ""
 }
 
+// EmptyProject checks if nothing of the project was entered
+func (backupConfig *BackupConfig) EmptyProject() bool {
+	return backupConfig.Command == "" && backupConfig.ProjectName == ""
+}
+
 // CheckPeriod validates the start time and interval period
 func (backupConfig *BackupConfig) CheckPeriod() bool {
 	return backupConfig.StartTime >= 0 && backupConfig.StartTime < 24 && backupConfig.Period > 0
 }
+
+// GetUploadWindows returns the upload windows of the project, falling back to the global ones
+func (backupConfig *BackupConfig) GetUploadWindows(s3Config S3Config) string {
+	if backupConfig.UploadWindows != "" {
+		return backupConfig.UploadWindows
+	}
+	return s3Config.UploadWindows
+}
+
+// GetProjectByID returns the project with the given id
+func (conf *Config) GetProjectByID(id string) (BackupConfig, bool) {
+	for _, backupConf := range conf.BackupConfig {
+		if id != "" && backupConf.ID == id {
+			return backupConf, true
+		}
+	}
+	return BackupConfig{}, false
+}
+
+// EnsureProjectIDs gives every project without an id, or with the id of a project before it, a new id.
+// It reports whether an id was assigned
+func (conf *Config) EnsureProjectIDs() (assigned bool) {
+	ids := make(map[string]bool)
+	for i := range conf.BackupConfig {
+		for conf.BackupConfig[i].ID == "" || ids[conf.BackupConfig[i].ID] {
+			conf.BackupConfig[i].ID = newProjectID()
+			assigned = true
+		}
+		ids[conf.BackupConfig[i].ID] = true
+	}
+	return assigned
+}
+
+// newProjectID returns a random project id
+func newProjectID() string {
+	random := make([]byte, 6)
+	rand.Read(random)
+	return hex.EncodeToString(random)
+}
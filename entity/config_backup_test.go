@@ -0,0 +1,139 @@
+package entity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestEnsureProjectIDs(t *testing.T) {
+	conf := Config{BackupConfig: []BackupConfig{{ID: "a"}, {}, {ID: "a"}}}
+	if !conf.EnsureProjectIDs() {
+		t.Fatal("Ids not assigned")
+	}
+	if conf.BackupConfig[0].ID != "a" || conf.BackupConfig[1].ID == "" || conf.BackupConfig[2].ID == "a" || conf.BackupConfig[1].ID == conf.BackupConfig[2].ID {
+		t.Errorf("Ids not correct: %+v", conf.BackupConfig)
+	}
+	if conf.EnsureProjectIDs() {
+		t.Error("Ids must be kept once assigned")
+	}
+	if project, ok := conf.GetProjectByID(conf.BackupConfig[1].ID); !ok || project.ID != conf.BackupConfig[1].ID {
+		t.Error("GetProjectByID not correct")
+	}
+	if _, ok := conf.GetProjectByID(""); ok {
+		t.Error("An empty id must not match")
+	}
+}
+
+// TestProjectIDMigration gives the projects of a config with fixed slots ids and removes the empty ones
+func TestProjectIDMigration(t *testing.T) {
+	useTempDir(t)
+
+	byt, _ := yaml.Marshal(Config{EncryptKey: "key", BackupConfig: []BackupConfig{{}, {ProjectName: "db", Command: "mysqldump"}, {}}})
+	ioutil.WriteFile(getConfigFilePath(), byt, 0600)
+
+	conf, err := GetConfigCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.BackupConfig) != 1 || conf.BackupConfig[0].ProjectName != "db" || conf.BackupConfig[0].ID == "" {
+		t.Fatalf("Projects not migrated: %+v", conf.BackupConfig)
+	}
+
+	cache.ConfigSingle = nil
+	reloaded, _ := GetConfigCache()
+	if reloaded.BackupConfig[0].ID != conf.BackupConfig[0].ID {
+		t.Error("The assigned id was not saved")
+	}
+	if history, _ := GetConfigHistory(); len(history) != 1 || history[0].Comment != "Assigned project ids" {
+		t.Errorf("The migration was not kept in the history: %+v", history)
+	}
+}
+
+func TestGetProjectPath(t *testing.T) {
+	absolute, _ := filepath.Abs(filepath.Join("mnt", "db"))
+	conf := Config{}
+	tests := []struct {
+		backupRoot string
+		outputDir  string
+		path       string
+	}{
+		{"", "", filepath.Join(parentSavePath, "shop")},
+		{"", "mysql", filepath.Join(parentSavePath, "mysql")},
+		{filepath.Join("data", "backups") + string(filepath.Separator), "", filepath.Join("data", "backups", "shop")},
+		{"data", filepath.Join("db", "daily"), filepath.Join("data", "db", "daily")},
+		{"data", absolute, absolute},
+	}
+	for _, test := range tests {
+		conf.BackupRoot = test.backupRoot
+		if path := conf.GetProjectPath(BackupConfig{ProjectName: "shop", OutputDir: test.outputDir}); path != test.path {
+			t.Errorf("%q %q: path %s instead of %s", test.backupRoot, test.outputDir, path, test.path)
+		}
+	}
+
+	conf.BackupConfig = []BackupConfig{
+		{ProjectName: "shop", Command: "mysqldump", Period: 60},
+		{ProjectName: "wiki", Command: "tar", Period: 60, OutputDir: "shop"},
+		{ProjectName: "logs", Command: "tar", Period: 60, OutputDir: ".."},
+		{ProjectName: "files", Command: "tar", Period: 60, OutputDir: absolute},
+		{ProjectName: "mail", Command: "tar", Period: 60, OutputDir: filepath.Join(absolute, "..", "db")},
+	}
+	errs := conf.Validate()
+	if len(errs) != 3 || errs[0].Field != "OutputDir" || errs[0].Index != 1 || errs[1].Field != "OutputDir" || errs[1].Index != 2 ||
+		errs[2].Field != "OutputDir" || errs[2].Index != 4 {
+		t.Errorf("Output directories not validated: %v", errs)
+	}
+}
+
+func TestMoveProjectDirs(t *testing.T) {
+	useTempDir(t)
+
+	conf := &Config{EncryptKey: "key", BackupConfig: []BackupConfig{
+		{ID: "shop", ProjectName: "shop", Command: "mysqldump", Period: 60},
+		{ID: "wiki", ProjectName: "wiki", Command: "tar", Period: 60},
+	}}
+	conf.SaveConfig()
+	os.MkdirAll(filepath.Join(parentSavePath, "shop"), 0750)
+	os.WriteFile(filepath.Join(parentSavePath, "shop", "2022-01-01-00-00.sql"), []byte("backup"), 0600)
+	os.MkdirAll(filepath.Join(parentSavePath, "wiki"), 0750)
+	os.MkdirAll(filepath.Join("data", "wiki"), 0750)
+
+	conf.BackupRoot = "data"
+	conf.BackupConfig[0].OutputDir = filepath.Join("mysql", "shop")
+	if err := conf.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join("data", "mysql", "shop", "2022-01-01-00-00.sql")); err != nil {
+		t.Error("Backups not moved to the new directory")
+	}
+	if _, err := os.Stat(filepath.Join(parentSavePath, "wiki")); err != nil {
+		t.Error("An existing directory must not be replaced")
+	}
+}
+
+// TestSetConfigFilePath keeps the configuration and its history in another directory
+func TestSetConfigFilePath(t *testing.T) {
+	useTempDir(t)
+	defer SetConfigFilePath(filepath.Join(parentSavePath, ".backup_x_config.yaml"))
+
+	SetConfigFilePath(filepath.Join("etc", "backup-x.yaml"))
+	conf := &Config{EncryptKey: "key", BackupConfig: []BackupConfig{{ProjectName: "shop", Command: "mysqldump", Period: 60}}}
+	if err := conf.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join("etc", "backup-x.yaml")); err != nil {
+		t.Error("Configuration not saved to the configured file")
+	}
+	if revisions, _ := GetConfigHistory(); len(revisions) != 1 {
+		t.Errorf("History not kept next to the configuration: %v", revisions)
+	}
+	if _, err := os.Stat(filepath.Join("etc", configHistoryDir)); err != nil {
+		t.Error("History not kept next to the configuration")
+	}
+	if _, err := os.Stat(parentSavePath); !os.IsNotExist(err) {
+		t.Error("The default directory must not be created")
+	}
+}
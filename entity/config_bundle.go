@@ -0,0 +1,181 @@
+package entity
+
+import (
+	"backup-x/util"
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// bundleVersion is the format version of exported bundles
+const bundleVersion = 1
+
+// bundleKeyCheck is encrypted into a bundle to tell a wrong passphrase from a corrupted bundle
+const bundleKeyCheck = "backup-x"
+
+// minBundlePassphrase is the minimum length of a bundle passphrase
+const minBundlePassphrase = 8
+
+// Import modes
+const (
+	ImportMerge   = "merge"   // Add or update projects, replicas, templates and secrets by name, keep everything else
+	ImportReplace = "replace" // Replace the whole configuration
+)
+
+// ConfigBundle is a portable copy of the configuration, its secrets encrypted with a key derived from a passphrase
+type ConfigBundle struct {
+	Version  int
+	Salt     string // argon2id salt of the passphrase
+	KeyCheck string // bundleKeyCheck encrypted with the bundle key
+	Config   Config
+}
+
+// Export returns a bundle of the configuration with every secret encrypted with the passphrase instead of EncryptKey
+func (conf Config) Export(passphrase string) ([]byte, error) {
+	if len(passphrase) < minBundlePassphrase {
+		return nil, fmt.Errorf("the passphrase must have at least %d characters", minBundlePassphrase)
+	}
+
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+	bundleKey, err := deriveEncryptKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if err := conf.reencrypt(bundleKey); err != nil {
+		return nil, err
+	}
+	keyCheck, err := util.EncryptByEncryptKey(bundleKey, bundleKeyCheck)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.EncryptKey = ""
+	conf.KeyID = ""
+	conf.KeySalt = ""
+	return yaml.Marshal(ConfigBundle{Version: bundleVersion, Salt: salt, KeyCheck: keyCheck, Config: conf})
+}
+
+// ImportBundle validates a bundle, encrypts its secrets with the EncryptKey of this host,
+// merges it into or replaces the configuration, and saves it
+func ImportBundle(data []byte, passphrase string, mode string) (conf Config, err error) {
+	if mode != ImportMerge && mode != ImportReplace {
+		return conf, fmt.Errorf("unknown import mode %s", mode)
+	}
+
+	var bundle ConfigBundle
+	if err := yaml.UnmarshalStrict(data, &bundle); err != nil {
+		return conf, fmt.Errorf("invalid bundle: %s", err)
+	}
+	if bundle.Version != bundleVersion {
+		return conf, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+	bundleKey, err := deriveEncryptKey(passphrase, bundle.Salt)
+	if err != nil {
+		return conf, fmt.Errorf("invalid bundle: %s", err)
+	}
+	if keyCheck, err := util.DecryptByEncryptKey(bundleKey, bundle.KeyCheck); err != nil || keyCheck != bundleKeyCheck {
+		return conf, errors.New("wrong passphrase")
+	}
+
+	imported := bundle.Config
+	if errs := imported.Validate(); len(errs) > 0 {
+		return conf, errs
+	}
+
+	// Without a configuration file the bundle is imported into an empty one
+	if conf, err = GetConfigCache(); err != nil && !os.IsNotExist(err) {
+		return conf, err
+	}
+	if conf.EncryptKey == "" {
+		if conf.EncryptKey, err = util.GenerateEncryptKey(); err != nil {
+			return conf, err
+		}
+	}
+	imported.EncryptKey = bundleKey
+	if err := imported.reencrypt(conf.EncryptKey); err != nil {
+		return conf, err
+	}
+
+	if mode == ImportReplace {
+		imported.KeyID = conf.KeyID
+		imported.KeySalt = conf.KeySalt
+		conf = imported
+	} else {
+		conf.mergeBundle(imported)
+		// Imported projects and secrets may collide with the ones of this host
+		if errs := conf.Validate(); len(errs) > 0 {
+			return conf, errs
+		}
+	}
+	return conf, conf.SaveConfigBy("", "Imported a bundle ("+mode+")")
+}
+
+// mergeBundle adds or updates the projects, replicas, templates and secrets of an imported config by name
+func (conf *Config) mergeBundle(imported Config) {
+	conf.deepCopySecrets()
+
+	conf.BackupConfig = append([]BackupConfig(nil), conf.BackupConfig...)
+	for _, backupConf := range imported.BackupConfig {
+		if !backupConf.NotEmptyProject() {
+			continue
+		}
+		replaced := false
+		for i := range conf.BackupConfig {
+			if conf.BackupConfig[i].ProjectName == backupConf.ProjectName {
+				// The project keeps its id on this host
+				backupConf.ID = conf.BackupConfig[i].ID
+				conf.BackupConfig[i] = backupConf
+				replaced = true
+			}
+		}
+		if !replaced {
+			conf.BackupConfig = append(conf.BackupConfig, backupConf)
+		}
+	}
+
+	conf.Replicas = append([]ReplicaTarget(nil), conf.Replicas...)
+	for _, replica := range imported.Replicas {
+		replaced := false
+		for i := range conf.Replicas {
+			if conf.Replicas[i].Name == replica.Name {
+				conf.Replicas[i] = replica
+				replaced = true
+			}
+		}
+		if !replaced {
+			conf.Replicas = append(conf.Replicas, replica)
+		}
+	}
+
+	conf.Templates = append([]ProjectTemplate(nil), conf.Templates...)
+	for _, tmpl := range imported.Templates {
+		replaced := false
+		for i := range conf.Templates {
+			if conf.Templates[i].Name == tmpl.Name {
+				conf.Templates[i] = tmpl
+				replaced = true
+			}
+		}
+		if !replaced {
+			conf.Templates = append(conf.Templates, tmpl)
+		}
+	}
+
+	for _, secret := range imported.Secrets {
+		replaced := false
+		for i := range conf.Secrets {
+			if conf.Secrets[i].Name == secret.Name {
+				conf.Secrets[i] = secret
+				replaced = true
+			}
+		}
+		if !replaced {
+			conf.Secrets = append(conf.Secrets, secret)
+		}
+	}
+}
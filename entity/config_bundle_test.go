@@ -0,0 +1,73 @@
+package entity
+
+import (
+	"backup-x/util"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestExportImportBundle moves a configuration to another host with a passphrase
+func TestExportImportBundle(t *testing.T) {
+	useTempDir(t)
+
+	sourceKey, _ := util.GenerateEncryptKey()
+	pwd, _ := util.EncryptByEncryptKey(sourceKey, "db-password")
+	source := Config{
+		EncryptKey:   sourceKey,
+		BackupConfig: []BackupConfig{{ProjectName: "db", Command: "mysqldump", Pwd: pwd, StartTime: 1, Period: 1440}},
+		Secrets:      []Secret{{Name: "TOKEN", Value: "env:TOKEN"}},
+	}
+	bundle, err := source.Export("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bundle), sourceKey) || strings.Contains(string(bundle), pwd) {
+		t.Error("The bundle must not contain the key or values encrypted with it")
+	}
+	if _, err := source.Export("short"); err == nil {
+		t.Error("A short passphrase must be rejected")
+	}
+
+	// The destination has its own key and projects
+	destKey, _ := util.GenerateEncryptKey()
+	dest := &Config{EncryptKey: destKey, BackupConfig: []BackupConfig{{ProjectName: "files", Command: "tar", StartTime: 1, Period: 60}, {}}}
+	dest.SaveConfig()
+
+	if _, err := ImportBundle(bundle, "wrong horse", ImportMerge); err == nil || err.Error() != "wrong passphrase" {
+		t.Errorf("Wrong passphrase not detected: %v", err)
+	}
+
+	merged, err := ImportBundle(bundle, "correct horse", ImportMerge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.BackupConfig) != 2 || merged.BackupConfig[0].ProjectName != "files" || merged.BackupConfig[1].ProjectName != "db" {
+		t.Errorf("Projects not merged: %+v", merged.BackupConfig)
+	}
+	if plainText, _ := util.DecryptByEncryptKey(destKey, merged.BackupConfig[1].Pwd); plainText != "db-password" || merged.EncryptKey != destKey {
+		t.Errorf("Secrets not encrypted with the destination key: %s", plainText)
+	}
+
+	// The merged configuration is validated, not only the bundle
+	clash := Config{EncryptKey: sourceKey, Secrets: []Secret{{Name: "token", Value: "env:TOKEN"}}}
+	clashBundle, _ := clash.Export("correct horse")
+	if _, err := ImportBundle(clashBundle, "correct horse", ImportMerge); err == nil {
+		t.Error("A secret only differing in case from an existing one must be rejected")
+	}
+
+	replaced, err := ImportBundle(bundle, "correct horse", ImportReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replaced.BackupConfig) != 1 || replaced.EncryptKey != destKey || replaced.Secrets[0].Value != "env:TOKEN" {
+		t.Errorf("Config not replaced: %+v", replaced)
+	}
+
+	// A configuration that cannot be read must not be replaced by the bundle under a new key
+	os.WriteFile(getConfigFilePath(), []byte("backupconfig: ["), 0600)
+	cache.ConfigSingle = nil
+	if _, err := ImportBundle(bundle, "correct horse", ImportReplace); err == nil {
+		t.Error("A broken configuration must be reported")
+	}
+}
@@ -0,0 +1,185 @@
+package entity
+
+import (
+	"backup-x/util"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrConfigReadOnly is returned when the configuration is saved while it is loaded from a declarative config file
+var ErrConfigReadOnly = errors.New("the configuration is read-only, change the declarative config file and restart backup-x")
+
+// declarativeFile is the path of the declarative config file, empty when the configuration is edited in the web interface
+var declarativeFile string
+
+// DeclarativeConfigFile returns the path of the declarative config file, empty unless the configuration is read-only
+func DeclarativeConfigFile() string {
+	return declarativeFile
+}
+
+// LoadDeclarativeConfig loads the configuration from a YAML or TOML file and makes it read-only.
+// ${ENV} in values is replaced with environment variables, passwords are given in plain text or as secret references.
+// The configuration must be valid, the errors of all fields are returned otherwise
+func LoadDeclarativeConfig(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	conf, err := parseDeclarativeConfig(data, filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+
+	cache.Lock.Lock()
+	defer cache.Lock.Unlock()
+	cache.ConfigSingle = &conf
+	cache.Err = nil
+	declarativeFile = path
+	return nil
+}
+
+// parseDeclarativeConfig reads and validates a declarative config file of the format of the extension,
+// and encrypts the passwords with a key that is only kept in memory
+func parseDeclarativeConfig(data []byte, ext string) (conf Config, err error) {
+	var tree interface{}
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		var table map[string]interface{}
+		_, err = toml.Decode(string(data), &table)
+		tree = table
+	default:
+		return conf, fmt.Errorf("unknown config file format %s, use .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return conf, fmt.Errorf("invalid config file: %s", err)
+	}
+
+	// Keys are the lowercase field names like in the configuration file of the web interface
+	if tree, err = expandEnvTree(tree); err != nil {
+		return conf, err
+	}
+	byt, err := yaml.Marshal(tree)
+	if err != nil {
+		return conf, err
+	}
+	if err := yaml.UnmarshalStrict(byt, &conf); err != nil {
+		return conf, fmt.Errorf("invalid config file: %s", err)
+	}
+	if conf.EncryptKey != "" || conf.KeyID != "" || conf.KeySalt != "" {
+		return conf, errors.New("the config file must not have an encryption key, give passwords in plain text or as secret references")
+	}
+
+	// Projects without an id get one from their name, so it stays the same when the file is loaded again
+	conf.removeEmptyProjects()
+	conf.unquoteReferences()
+	for i := range conf.BackupConfig {
+		if conf.BackupConfig[i].ID == "" {
+			conf.BackupConfig[i].ID = declarativeProjectID(conf.BackupConfig[i].ProjectName)
+		}
+	}
+
+	errs := conf.Validate()
+	if conf.Username == "" {
+		errs.Add("Username", -1, "Please enter the username")
+	}
+	if conf.Password == "" {
+		errs.Add("Password", -1, "Please enter the password")
+	}
+	ids := make(map[string]bool)
+	for i, backupConf := range conf.BackupConfig {
+		if ids[backupConf.ID] {
+			errs.Add("ID", i, "Project %s: the id %s is used twice", backupConf.ProjectName, backupConf.ID)
+		}
+		ids[backupConf.ID] = true
+	}
+	if len(errs) > 0 {
+		return conf, errs
+	}
+
+	if conf.EncryptKey, err = util.GenerateEncryptKey(); err != nil {
+		return conf, err
+	}
+	for _, field := range conf.getEncryptedFields() {
+		if *field, err = util.EncryptByEncryptKey(conf.EncryptKey, *field); err != nil {
+			return conf, err
+		}
+	}
+	return conf, nil
+}
+
+// expandEnvTree replaces environment variables in the values of a parsed config file and lowercases the keys.
+// A value that is only a variable becomes a number if the variable is one
+func expandEnvTree(node interface{}) (interface{}, error) {
+	switch value := node.(type) {
+	case map[interface{}]interface{}:
+		expanded := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			child, err := expandEnvTree(child)
+			if err != nil {
+				return nil, err
+			}
+			expanded[strings.ToLower(fmt.Sprint(key))] = child
+		}
+		return expanded, nil
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			child, err := expandEnvTree(child)
+			if err != nil {
+				return nil, err
+			}
+			expanded[strings.ToLower(key)] = child
+		}
+		return expanded, nil
+	case []map[string]interface{}:
+		expanded := make([]interface{}, len(value))
+		for i, child := range value {
+			var err error
+			if expanded[i], err = expandEnvTree(child); err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	case []interface{}:
+		expanded := make([]interface{}, len(value))
+		for i, child := range value {
+			var err error
+			if expanded[i], err = expandEnvTree(child); err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	case string:
+		text, err := util.ExpandEnv(value)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(value, "${") && strings.Index(value, "}") == len(value)-1 {
+			if number, err := strconv.Atoi(text); err == nil && strconv.Itoa(number) == text {
+				return number, nil
+			}
+			if number, err := strconv.ParseFloat(text, 64); err == nil && strconv.FormatFloat(number, 'f', -1, 64) == text {
+				return number, nil
+			}
+		}
+		return text, nil
+	}
+	return node, nil
+}
+
+// declarativeProjectID returns the id of a project of a declarative config file without an id
+func declarativeProjectID(projectName string) string {
+	sum := sha256.Sum256([]byte(projectName))
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,122 @@
+package entity
+
+import (
+	"backup-x/util"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const declarativeYAML = `
+user:
+  username: admin
+  password: ${BACKUP_X_DECLARATIVE_PWD}
+BackupConfig:
+- projectname: shop
+  command: "mysqldump -h ${BACKUP_X_DECLARATIVE_HOST:-127.0.0.1} shop > #{DATE}.sql"
+  pwd: ${BACKUP_X_DECLARATIVE_PWD}
+  savedays: ${BACKUP_X_DECLARATIVE_DAYS}
+  period: 1440
+- id: files
+  projectname: files
+  command: "tar -czf #{DATE}.tar.gz -C $${HOME} ."
+  period: 60
+s3config:
+  secretkey: env:S3_SECRET_KEY
+`
+
+const declarativeTOML = `
+[user]
+username = "admin"
+password = "${BACKUP_X_DECLARATIVE_PWD}"
+
+[[backupconfig]]
+projectname = "shop"
+command = "mysqldump -h ${BACKUP_X_DECLARATIVE_HOST:-127.0.0.1} shop > #{DATE}.sql"
+pwd = "${BACKUP_X_DECLARATIVE_PWD}"
+savedays = "${BACKUP_X_DECLARATIVE_DAYS}"
+period = 1440
+
+[[backupconfig]]
+id = "files"
+projectname = "files"
+command = "tar -czf #{DATE}.tar.gz -C $${HOME} ."
+period = 60
+
+[s3config]
+secretkey = "env:S3_SECRET_KEY"
+`
+
+func TestParseDeclarativeConfig(t *testing.T) {
+	t.Setenv("BACKUP_X_DECLARATIVE_PWD", "007")
+	t.Setenv("BACKUP_X_DECLARATIVE_DAYS", "14")
+	t.Setenv("BACKUP_X_DECLARATIVE_HOST", "")
+	os.Unsetenv("BACKUP_X_DECLARATIVE_HOST")
+
+	for ext, data := range map[string]string{".yaml": declarativeYAML, ".toml": declarativeTOML} {
+		conf, err := parseDeclarativeConfig([]byte(data), ext)
+		if err != nil {
+			t.Fatalf("%s: %s", ext, err)
+		}
+		if len(conf.BackupConfig) != 2 {
+			t.Fatalf("%s: projects not loaded: %+v", ext, conf.BackupConfig)
+		}
+		shop, files := conf.BackupConfig[0], conf.BackupConfig[1]
+		if shop.Command != "mysqldump -h 127.0.0.1 shop > #{DATE}.sql" || shop.SaveDays != 14 {
+			t.Errorf("%s: environment variables not replaced: %+v", ext, shop)
+		}
+		if files.Command != "tar -czf #{DATE}.tar.gz -C ${HOME} ." {
+			t.Errorf("%s: $${ not kept: %s", ext, files.Command)
+		}
+		if shop.ID != declarativeProjectID("shop") || files.ID != "files" {
+			t.Errorf("%s: ids not correct: %s %s", ext, shop.ID, files.ID)
+		}
+		if pwd, err := util.DecryptByEncryptKey(conf.EncryptKey, shop.Pwd); err != nil || pwd != "007" {
+			t.Errorf("%s: password not encrypted: %s %v", ext, pwd, err)
+		}
+		if conf.SecretKey != "env:S3_SECRET_KEY" {
+			t.Errorf("%s: secret reference changed: %s", ext, conf.SecretKey)
+		}
+	}
+
+	if _, err := parseDeclarativeConfig([]byte("user:\n  username: admin\nbackupconfig:\n- projectname: shop\n  period: 0\n"), ".yml"); err == nil {
+		t.Error("An invalid config must be rejected")
+	} else if errs, ok := err.(ValidationErrors); !ok || len(errs) != 3 {
+		t.Errorf("Validation errors not correct: %v", err)
+	}
+	if _, err := parseDeclarativeConfig([]byte("unknown: 1\n"), ".yaml"); err == nil {
+		t.Error("Unknown keys must be rejected")
+	}
+	t.Setenv("BACKUP_X_DECLARATIVE_PWD", "")
+	os.Unsetenv("BACKUP_X_DECLARATIVE_PWD")
+	if _, err := parseDeclarativeConfig([]byte(declarativeYAML), ".yaml"); err == nil {
+		t.Error("A missing environment variable must be rejected")
+	}
+}
+
+func TestLoadDeclarativeConfig(t *testing.T) {
+	useTempDir(t)
+	defer func() { declarativeFile = "" }()
+
+	t.Setenv("BACKUP_X_DECLARATIVE_PWD", "secret")
+	t.Setenv("BACKUP_X_DECLARATIVE_DAYS", "7")
+	path := filepath.Join(t.TempDir(), "backup-x.yaml")
+	os.WriteFile(path, []byte(declarativeYAML), 0600)
+	if err := LoadDeclarativeConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if DeclarativeConfigFile() != path {
+		t.Errorf("Declarative config file not kept: %s", DeclarativeConfigFile())
+	}
+
+	conf, err := GetConfigCache()
+	if err != nil || len(conf.BackupConfig) != 2 {
+		t.Fatalf("Declarative config not used: %v", err)
+	}
+	if err := conf.SaveConfig(); err != ErrConfigReadOnly {
+		t.Errorf("A declarative config must not be saved: %v", err)
+	}
+	if _, err := os.Stat(getConfigFilePath()); !os.IsNotExist(err) {
+		t.Error("No configuration file must be written")
+	}
+}
@@ -0,0 +1,103 @@
+package entity
+
+import (
+	"backup-x/util"
+	"fmt"
+	"log"
+	"os"
+)
+
+// getEncryptedFields returns every value in the config encrypted with EncryptKey, skipping references
+func (conf *Config) getEncryptedFields() []*string {
+	fields := []*string{&conf.Password, &conf.S3Config.SecretKey, &conf.Vault.Token, &conf.Vault.SecretID}
+	for i := range conf.BackupConfig {
+		fields = append(fields, &conf.BackupConfig[i].Pwd)
+	}
+	for i := range conf.Replicas {
+		fields = append(fields, &conf.Replicas[i].SecretKey)
+	}
+	for i := range conf.Secrets {
+		fields = append(fields, &conf.Secrets[i].Value)
+	}
+
+	encrypted := make([]*string, 0, len(fields))
+	for _, field := range fields {
+		if *field != "" && !IsSecretRef(*field) {
+			encrypted = append(encrypted, field)
+		}
+	}
+	return encrypted
+}
+
+// hasLegacyCipherText checks whether a value still uses the fixed nonce of the EncryptKey
+func (conf *Config) hasLegacyCipherText() bool {
+	for _, field := range conf.getEncryptedFields() {
+		if util.IsLegacyCipherText(*field) {
+			return true
+		}
+	}
+	return false
+}
+
+// reencrypt decrypts every encrypted value with EncryptKey and encrypts it again with newEncryptKey.
+// Nothing is changed if a value cannot be decrypted
+func (conf *Config) reencrypt(newEncryptKey string) error {
+	conf.deepCopySecrets()
+	fields := conf.getEncryptedFields()
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		plainText, err := util.DecryptByEncryptKey(conf.EncryptKey, *field)
+		if err != nil {
+			return fmt.Errorf("decryption failed, the config may be corrupted: %s", err)
+		}
+		values[i], err = util.EncryptByEncryptKey(newEncryptKey, plainText)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, field := range fields {
+		*field = values[i]
+	}
+	conf.EncryptKey = newEncryptKey
+	return nil
+}
+
+// RotateEncryptKey encrypts every secret in the config under a new EncryptKey and saves the config.
+// The key is generated unless a master key is used, which is then rotated to the key in BACKUP_X_NEW_ENCRYPT_KEY
+func RotateEncryptKey() error {
+	conf, err := GetConfigCache()
+	if err != nil {
+		return err
+	}
+
+	oldEncryptKey := conf.EncryptKey
+	var newEncryptKey string
+	if HasMasterKey() {
+		newEncryptKey = os.Getenv(NewEncryptKeyEnv)
+		if newEncryptKey == "" {
+			return fmt.Errorf("the config is encrypted with a master key, provide the new master key in %s", NewEncryptKeyEnv)
+		}
+	} else if newEncryptKey, err = util.GenerateEncryptKey(); err != nil {
+		return err
+	}
+	if err := conf.reencrypt(newEncryptKey); err != nil {
+		return err
+	}
+	if HasMasterKey() {
+		if err := SetMasterKey(newEncryptKey); err != nil {
+			return err
+		}
+		log.Printf("Rotated to the master key %s, provide it from now on instead of the old one\n", GetKeyID(newEncryptKey))
+	}
+
+	log.Printf("Encrypted %d values under a new key\n", len(conf.getEncryptedFields()))
+	if err := conf.SaveConfigBy("", "Rotated the encryption key"); err != nil {
+		return err
+	}
+
+	// The revisions are encrypted under the new key as well, the old key is not kept anywhere
+	cache.Lock.Lock()
+	defer cache.Lock.Unlock()
+	return reencryptConfigHistory(oldEncryptKey, newEncryptKey)
+}
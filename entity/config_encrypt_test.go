@@ -0,0 +1,47 @@
+package entity
+
+import (
+	"backup-x/util"
+	"testing"
+)
+
+// TestMigrateAndRotateEncryptKey migrates legacy values on load and re-encrypts every secret under a new key
+func TestMigrateAndRotateEncryptKey(t *testing.T) {
+	useTempDir(t)
+
+	encryptKey, _ := util.GenerateEncryptKey()
+	key, nonce, _ := util.ValidateKeyAndNonce(encryptKey[0:64], encryptKey[64:88])
+	legacy, _ := util.Encrypt(key, nonce, "db-password")
+	current, _ := util.EncryptByEncryptKey(encryptKey, "s3-secret")
+
+	conf := &Config{
+		EncryptKey:   encryptKey,
+		BackupConfig: []BackupConfig{{ProjectName: "db", Pwd: legacy}},
+		S3Config:     S3Config{SecretKey: current},
+		Secrets:      []Secret{{Name: "TOKEN", Value: "env:TOKEN"}},
+	}
+	if err := conf.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, _ := GetConfigCache()
+	if !util.IsLegacyCipherText(legacy) || util.IsLegacyCipherText(loaded.BackupConfig[0].Pwd) {
+		t.Errorf("Legacy value not migrated: %s", loaded.BackupConfig[0].Pwd)
+	}
+	if pwd, _ := util.DecryptByEncryptKey(encryptKey, loaded.BackupConfig[0].Pwd); pwd != "db-password" {
+		t.Errorf("Migrated value not correct: %s", pwd)
+	}
+
+	if err := RotateEncryptKey(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := GetConfigCache()
+	if rotated.EncryptKey == encryptKey {
+		t.Fatal("EncryptKey not rotated")
+	}
+	pwd, _ := util.DecryptByEncryptKey(rotated.EncryptKey, rotated.BackupConfig[0].Pwd)
+	secretKey, _ := util.DecryptByEncryptKey(rotated.EncryptKey, rotated.SecretKey)
+	if pwd != "db-password" || secretKey != "s3-secret" || rotated.Secrets[0].Value != "env:TOKEN" {
+		t.Errorf("Rotated values not correct: %s, %s, %s", pwd, secretKey, rotated.Secrets[0].Value)
+	}
+}
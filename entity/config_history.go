@@ -0,0 +1,324 @@
+package entity
+
+import (
+	"backup-x/util"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configHistoryDir keeps previous configurations next to the configuration file
+const configHistoryDir = ".backup_x_config_history"
+
+// configHistoryIndex lists the revisions inside configHistoryDir
+const configHistoryIndex = "history.yaml"
+
+// maxConfigHistory is the number of revisions kept, older ones are removed
+const maxConfigHistory = 50
+
+// maskedValue replaces secret values when showing changes
+const maskedValue = "******"
+
+// ConfigRevision is a saved configuration, who saved it and what changed compared to the one before
+type ConfigRevision struct {
+	ID        string         `yaml:"id"`
+	CreatedAt time.Time      `yaml:"createdat"`
+	Author    string         `yaml:"author,omitempty"`
+	Comment   string         `yaml:"comment,omitempty"`
+	KeyID     string         `yaml:"keyid,omitempty"` // Identifies the EncryptKey the secrets of the revision are encrypted with
+	Changes   []ConfigChange `yaml:"changes,omitempty"`
+}
+
+// ConfigChange is a changed field, secret values are masked
+type ConfigChange struct {
+	Field string `yaml:"field"`
+	Old   string `yaml:"old,omitempty"`
+	New   string `yaml:"new,omitempty"`
+}
+
+// GetConfigHistory returns the saved revisions, the newest first
+func GetConfigHistory() ([]ConfigRevision, error) {
+	cache.Lock.Lock()
+	defer cache.Lock.Unlock()
+	return readConfigHistory()
+}
+
+// RollbackConfig saves the configuration of a revision again as a new revision.
+// The secrets of the revision are encrypted under the current EncryptKey, which is kept
+func RollbackConfig(id string, author string) (conf Config, err error) {
+	current, err := GetConfigCache()
+	if err != nil {
+		return conf, err
+	}
+	if current.EncryptKey == "" {
+		return conf, fmt.Errorf("the configuration has no encryption key")
+	}
+	history, err := GetConfigHistory()
+	if err != nil {
+		return conf, err
+	}
+	found := false
+	for _, revision := range history {
+		found = found || revision.ID == id
+	}
+	if !found {
+		return conf, fmt.Errorf("revision %s not found", id)
+	}
+
+	byt, err := ioutil.ReadFile(getConfigRevisionPath(id))
+	if err != nil {
+		return conf, err
+	}
+	if err = yaml.Unmarshal(byt, &conf); err != nil {
+		return conf, err
+	}
+
+	// Revisions of older versions still have their own key
+	if conf.EncryptKey == "" {
+		if conf.KeyID != GetKeyID(current.EncryptKey) {
+			return conf, fmt.Errorf("revision %s is encrypted with another key", id)
+		}
+		conf.EncryptKey = current.EncryptKey
+	}
+	if err = conf.reencrypt(current.EncryptKey); err != nil {
+		return conf, err
+	}
+	conf.KeyID = current.KeyID
+	conf.KeySalt = current.KeySalt
+	return conf, conf.SaveConfigBy(author, "Rolled back to "+id)
+}
+
+// addConfigRevision stores the saved configuration without its EncryptKey and the changes of the written file
+// compared to the previous one
+func addConfigRevision(oldByt []byte, newByt []byte, conf *Config, author string, comment string) error {
+	dir := getConfigHistoryPath()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	history, err := readConfigHistory()
+	if err != nil {
+		return err
+	}
+
+	revision := ConfigRevision{
+		ID:        time.Now().Format("20060102-150405.000000"),
+		CreatedAt: time.Now(),
+		Author:    author,
+		Comment:   comment,
+		KeyID:     GetKeyID(conf.EncryptKey),
+	}
+	if oldByt == nil {
+		if revision.Comment == "" {
+			revision.Comment = "Initial configuration"
+		}
+	} else {
+		revision.Changes = diffConfigFiles(oldByt, newByt)
+		if len(revision.Changes) == 0 && revision.Comment == "" {
+			return nil
+		}
+	}
+
+	if err := writeConfigRevision(revision.ID, *conf); err != nil {
+		return err
+	}
+	history = append([]ConfigRevision{revision}, history...)
+	for len(history) > maxConfigHistory {
+		os.Remove(getConfigRevisionPath(history[len(history)-1].ID))
+		history = history[:len(history)-1]
+	}
+	return writeConfigHistory(history)
+}
+
+// writeConfigRevision writes the file of a revision with the id of its EncryptKey instead of the key
+func writeConfigRevision(id string, conf Config) error {
+	conf.KeyID = GetKeyID(conf.EncryptKey)
+	conf.EncryptKey = ""
+	byt, err := yaml.Marshal(conf)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(getConfigRevisionPath(id), byt, 0600)
+}
+
+// writeConfigHistory writes the revision index
+func writeConfigHistory(history []ConfigRevision) error {
+	byt, err := yaml.Marshal(history)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(filepath.Join(getConfigHistoryPath(), configHistoryIndex), byt, 0600)
+}
+
+// reencryptConfigHistory encrypts the revisions under newEncryptKey. A revision is decrypted with the key
+// older versions kept in it, or with oldEncryptKey; revisions that cannot be decrypted are removed.
+// Revisions already encrypted with newEncryptKey are kept as they are
+func reencryptConfigHistory(oldEncryptKey string, newEncryptKey string) error {
+	history, err := readConfigHistory()
+	if err != nil {
+		return err
+	}
+	kept := make([]ConfigRevision, 0, len(history))
+	for _, revision := range history {
+		if revision.KeyID == GetKeyID(newEncryptKey) {
+			kept = append(kept, revision)
+			continue
+		}
+
+		var conf Config
+		byt, err := ioutil.ReadFile(getConfigRevisionPath(revision.ID))
+		if err == nil {
+			err = yaml.Unmarshal(byt, &conf)
+		}
+		if err == nil {
+			if conf.EncryptKey == "" {
+				conf.EncryptKey = oldEncryptKey
+			}
+			err = conf.reencrypt(newEncryptKey)
+		}
+		if err == nil {
+			err = writeConfigRevision(revision.ID, conf)
+		}
+		if err != nil {
+			log.Printf("Removed revision %s of the configuration history, it cannot be encrypted under the current key: %s\n", revision.ID, err)
+			os.Remove(getConfigRevisionPath(revision.ID))
+			continue
+		}
+		revision.KeyID = GetKeyID(newEncryptKey)
+		kept = append(kept, revision)
+	}
+	return writeConfigHistory(kept)
+}
+
+// hasForeignKeyRevisions checks whether a revision is not known to be encrypted with encryptKey
+func hasForeignKeyRevisions(encryptKey string) bool {
+	history, err := readConfigHistory()
+	if err != nil {
+		return false
+	}
+	for _, revision := range history {
+		if revision.KeyID != GetKeyID(encryptKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// readConfigHistory reads the revision index, a missing index is an empty history
+func readConfigHistory() (history []ConfigRevision, err error) {
+	byt, err := ioutil.ReadFile(filepath.Join(getConfigHistoryPath(), configHistoryIndex))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(byt, &history)
+	return history, err
+}
+
+// diffConfigFiles compares two configuration files field by field
+func diffConfigFiles(oldByt []byte, newByt []byte) (changes []ConfigChange) {
+	oldFields, oldOrder := flattenConfigFile(oldByt)
+	newFields, newOrder := flattenConfigFile(newByt)
+
+	for _, field := range newOrder {
+		if oldValue, ok := oldFields[field]; !ok || oldValue != newFields[field] {
+			changes = append(changes, maskConfigChange(ConfigChange{Field: field, Old: oldValue, New: newFields[field]}))
+		}
+	}
+	for _, field := range oldOrder {
+		if _, ok := newFields[field]; !ok {
+			changes = append(changes, maskConfigChange(ConfigChange{Field: field, Old: oldFields[field]}))
+		}
+	}
+	return changes
+}
+
+// flattenConfigFile maps the path of every value in a configuration file to the value, e.g. backupconfig[db].command.
+// List items are named by their projectname or name
+func flattenConfigFile(byt []byte) (fields map[string]string, order []string) {
+	fields = map[string]string{}
+	var root yaml.MapSlice
+	if yaml.Unmarshal(byt, &root) != nil {
+		return fields, nil
+	}
+
+	var walk func(path string, value interface{})
+	walk = func(path string, value interface{}) {
+		switch v := value.(type) {
+		case yaml.MapSlice:
+			for _, item := range v {
+				key := fmt.Sprint(item.Key)
+				if path != "" {
+					key = path + "." + key
+				}
+				walk(key, item.Value)
+			}
+		case []interface{}:
+			for i, item := range v {
+				walk(fmt.Sprintf("%s[%s]", path, listItemName(item, i)), item)
+			}
+		default:
+			if value == nil {
+				return
+			}
+			fields[path] = fmt.Sprint(value)
+			order = append(order, path)
+		}
+	}
+	walk("", root)
+	return fields, order
+}
+
+// listItemName names a list item by its projectname or name, or by its index
+func listItemName(item interface{}, index int) string {
+	if mapSlice, ok := item.(yaml.MapSlice); ok {
+		for _, field := range mapSlice {
+			if (field.Key == "projectname" || field.Key == "name") && fmt.Sprint(field.Value) != "" {
+				return fmt.Sprint(field.Value)
+			}
+		}
+	}
+	return fmt.Sprint(index)
+}
+
+// maskConfigChange hides the values of secret fields
+func maskConfigChange(change ConfigChange) ConfigChange {
+	field := change.Field[strings.LastIndex(change.Field, ".")+1:]
+	switch field {
+	case "password", "pwd", "secretkey", "token", "secretid", "encryptkey":
+	case "value":
+		if !strings.HasPrefix(change.Field, "secrets[") {
+			return change
+		}
+	default:
+		return change
+	}
+	change.Old = maskSecretValue(change.Old)
+	change.New = maskSecretValue(change.New)
+	return change
+}
+
+// maskSecretValue masks a secret value, references to external secrets are shown
+func maskSecretValue(value string) string {
+	if value == "" || IsSecretRef(value) {
+		return value
+	}
+	return maskedValue
+}
+
+// getConfigHistoryPath returns the directory of the configuration history
+func getConfigHistoryPath() string {
+	return filepath.Join(getDataPath(), configHistoryDir)
+}
+
+// getConfigRevisionPath returns the file of a revision
+func getConfigRevisionPath(id string) string {
+	return filepath.Join(getConfigHistoryPath(), id+".yaml")
+}
@@ -0,0 +1,87 @@
+package entity
+
+import (
+	"backup-x/util"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestConfigHistory keeps every saved configuration and rolls back to one
+func TestConfigHistory(t *testing.T) {
+	useTempDir(t)
+
+	encryptKey, _ := util.GenerateEncryptKey()
+	pwd, _ := util.EncryptByEncryptKey(encryptKey, "db-password")
+	conf := &Config{EncryptKey: encryptKey, BackupConfig: []BackupConfig{{ProjectName: "db", Command: "mysqldump", Pwd: pwd, Period: 60}}}
+	if err := conf.SaveConfigBy("admin", ""); err != nil {
+		t.Fatal(err)
+	}
+	conf.SaveConfigBy("admin", "")
+
+	newPwd, _ := util.EncryptByEncryptKey(encryptKey, "new-password")
+	conf.BackupConfig[0].Command = "pg_dump"
+	conf.BackupConfig[0].Pwd = newPwd
+	if err := conf.SaveConfigBy("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := GetConfigHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Saving an unchanged config must not add a revision: %d", len(history))
+	}
+	latest := history[0]
+	if latest.Author != "alice" || len(latest.Changes) != 2 {
+		t.Fatalf("Revision not correct: %+v", latest)
+	}
+	if latest.Changes[0] != (ConfigChange{Field: "backupconfig[db].command", Old: "mysqldump", New: "pg_dump"}) {
+		t.Errorf("Change not correct: %+v", latest.Changes[0])
+	}
+	if latest.Changes[1].Old != maskedValue || latest.Changes[1].New != maskedValue {
+		t.Errorf("Secret values must be masked: %+v", latest.Changes[1])
+	}
+
+	rolledBack, err := RollbackConfig(history[1].ID, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := GetConfigCache()
+	if plain, _ := util.DecryptByEncryptKey(saved.EncryptKey, saved.BackupConfig[0].Pwd); rolledBack.BackupConfig[0].Command != "mysqldump" ||
+		saved.BackupConfig[0].Command != "mysqldump" || plain != "db-password" {
+		t.Errorf("Rollback not correct: %+v", saved.BackupConfig[0])
+	}
+	history, _ = GetConfigHistory()
+	if len(history) != 3 || history[0].Author != "bob" || history[0].Comment != "Rolled back to "+history[2].ID {
+		t.Errorf("Rollback not recorded: %+v", history[0])
+	}
+	for _, revision := range history {
+		byt, _ := os.ReadFile(getConfigRevisionPath(revision.ID))
+		if strings.Contains(string(byt), encryptKey) {
+			t.Errorf("Revision %s keeps the EncryptKey", revision.ID)
+		}
+	}
+
+	// Revisions are encrypted under the new key when it is rotated, a rollback keeps the current key
+	if err := RotateEncryptKey(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := GetConfigCache()
+	history, _ = GetConfigHistory()
+	if len(history) != 4 {
+		t.Fatalf("Revisions lost on rotation: %d", len(history))
+	}
+	if _, err := RollbackConfig(history[3].ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ = GetConfigCache()
+	if plain, _ := util.DecryptByEncryptKey(rotated.EncryptKey, saved.BackupConfig[0].Pwd); saved.EncryptKey != rotated.EncryptKey || plain != "db-password" {
+		t.Errorf("Rollback after rotation not correct: %+v", saved.BackupConfig[0])
+	}
+
+	if _, err := RollbackConfig("history", "bob"); err == nil {
+		t.Error("Unknown revisions must be rejected")
+	}
+}
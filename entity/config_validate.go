@@ -0,0 +1,228 @@
+package entity
+
+import (
+	"backup-x/util"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// FieldError is a validation error of a form field. Index is the position of the project,
+// replica target or secret the field belongs to, or -1 for a global field
+type FieldError struct {
+	Field   string `json:"field"`
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors are all validation errors of a configuration
+type ValidationErrors []FieldError
+
+// Error joins the messages of all errors
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add appends an error of a field
+func (errs *ValidationErrors) Add(field string, index int, format string, args ...interface{}) {
+	*errs = append(*errs, FieldError{Field: field, Index: index, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the projects, object storage, replica targets, templates and secrets before the configuration is saved
+func (conf *Config) Validate() (errs ValidationErrors) {
+	projectNames := make(map[string]bool)
+	projectPaths := make(map[string]string)
+	for i, backupConf := range conf.BackupConfig {
+		if backupConf.ProjectName == "" && backupConf.Command == "" {
+			continue
+		}
+		label := backupConf.ProjectName
+		if label == "" {
+			label = fmt.Sprint(i)
+		}
+
+		duplicateName := projectNames[backupConf.ProjectName]
+		if backupConf.ProjectName == "" {
+			errs.Add("ProjectName", i, "Project %s: please enter the project name", label)
+		} else if err := CheckProjectName(backupConf.ProjectName); err != nil {
+			errs.Add("ProjectName", i, "Project %s: %s", label, err)
+		} else if duplicateName {
+			errs.Add("ProjectName", i, "Project %s is defined twice", label)
+		}
+		projectNames[backupConf.ProjectName] = true
+
+		if strings.TrimSpace(backupConf.Command) == "" {
+			errs.Add("Command", i, "Project %s: please enter the backup script", label)
+		}
+		for _, command := range []struct{ field, text string }{{"Command", backupConf.Command}, {"PreHook", backupConf.PreHook},
+			{"PostSuccessHook", backupConf.PostSuccessHook}, {"PostFailureHook", backupConf.PostFailureHook}, {"CleanupHook", backupConf.CleanupHook}} {
+			if ref := QuotedReference(command.text); ref != "" {
+				errs.Add(command.field, i, "Project %s: %s expands to a quoted value itself, move it out of the quotes", label, ref)
+			}
+		}
+		if backupConf.StartTime < 0 || backupConf.StartTime > 23 {
+			errs.Add("StartTime", i, "Project %s: the start time must be between 0 and 23", label)
+		}
+		if backupConf.Period <= 0 {
+			errs.Add("Period", i, "Project %s: the period must be at least 1 minute", label)
+		}
+		if backupConf.SaveDays < 0 {
+			errs.Add("SaveDays", i, "Project %s: the local retention days must not be negative", label)
+		}
+		if backupConf.SaveDaysS3 < 0 {
+			errs.Add("SaveDaysS3", i, "Project %s: the object storage retention days must not be negative", label)
+		}
+		if backupConf.BackupType != 0 && backupConf.BackupType != 1 {
+			errs.Add("BackupType", i, "Project %s: unknown backup type %d", label, backupConf.BackupType)
+		}
+		if backupConf.Enabled != 0 && backupConf.Enabled != 1 {
+			errs.Add("Enabled", i, "Project %s: enabled must be 0 or 1", label)
+		}
+		if backupConf.UploadRequired != 0 && backupConf.UploadRequired != 1 {
+			errs.Add("UploadRequired", i, "Project %s: upload required must be 0 or 1", label)
+		}
+		if backupConf.MaxUploadKBps < 0 {
+			errs.Add("MaxUploadKBps", i, "Project %s: the upload limit must not be negative", label)
+		}
+		if backupConf.MaxDownloadKBps < 0 {
+			errs.Add("MaxDownloadKBps", i, "Project %s: the download limit must not be negative", label)
+		}
+		if _, err := util.ParseTimeWindows(backupConf.UploadWindows); err != nil {
+			errs.Add("UploadWindows", i, "Project %s: %s", label, err)
+		}
+		if dir := filepath.Clean(backupConf.OutputDir); backupConf.OutputDir != "" && !filepath.IsAbs(dir) &&
+			(dir == "." || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator))) {
+			errs.Add("OutputDir", i, "Project %s: a relative output directory must be inside the backup root, use an absolute path instead", label)
+		} else if other, ok := projectPaths[conf.absProjectPath(backupConf)]; ok && !(duplicateName && backupConf.OutputDir == "") {
+			errs.Add("OutputDir", i, "Project %s: the output directory is also used by project %s", label, other)
+		}
+		projectPaths[conf.absProjectPath(backupConf)] = label
+		if !conf.CheckStorageTarget(backupConf.StorageTarget) {
+			errs.Add("StorageTarget", i, "Project %s: unknown storage target %s", label, backupConf.StorageTarget)
+		}
+		for _, tag := range backupConf.Tags {
+			if tag == "" || strings.Contains(tag, ",") {
+				errs.Add("Tags", i, "Project %s: tags must not be empty or contain commas", label)
+				break
+			}
+		}
+		backupConf.BackupRetry.validate(&errs, "BackupRetry", i, label)
+		backupConf.UploadRetry.validate(&errs, "UploadRetry", i, label)
+	}
+
+	if conf.PartSize < 0 {
+		errs.Add("PartSize", -1, "The part size must not be negative")
+	}
+	if conf.Concurrency < 0 {
+		errs.Add("Concurrency", -1, "The concurrency must not be negative")
+	}
+	if conf.S3Config.MaxUploadKBps < 0 {
+		errs.Add("S3MaxUploadKBps", -1, "The upload limit must not be negative")
+	}
+	if conf.S3Config.MaxDownloadKBps < 0 {
+		errs.Add("S3MaxDownloadKBps", -1, "The download limit must not be negative")
+	}
+	if _, err := util.ParseTimeWindows(conf.S3Config.UploadWindows); err != nil {
+		errs.Add("S3UploadWindows", -1, "%s", err)
+	}
+
+	replicaNames := make(map[string]bool)
+	for i, replica := range conf.Replicas {
+		if replica.Name == "" {
+			errs.Add("ReplicaName", i, "A replica target has no name")
+		} else if replica.Name == StorageTargetLocal || replica.Name == "s3" {
+			errs.Add("ReplicaName", i, "The replica target name %s is reserved", replica.Name)
+		} else if replicaNames[replica.Name] {
+			errs.Add("ReplicaName", i, "Replica target %s is defined twice", replica.Name)
+		}
+		replicaNames[replica.Name] = true
+		if replica.SaveDays < 0 {
+			errs.Add("ReplicaSaveDays", i, "Replica target %s: the retention days must not be negative", replica.Name)
+		}
+	}
+
+	templateNames := make(map[string]bool)
+	for i, tmpl := range conf.Templates {
+		if strings.TrimSpace(tmpl.Name) == "" {
+			errs.Add("TemplateName", i, "A template has no name")
+		} else if isBuiltinTemplate(tmpl.Name) {
+			errs.Add("TemplateName", i, "The template name %s is used by a built-in template", tmpl.Name)
+		} else if templateNames[tmpl.Name] {
+			errs.Add("TemplateName", i, "Template %s is defined twice", tmpl.Name)
+		}
+		templateNames[tmpl.Name] = true
+		if strings.TrimSpace(tmpl.Command) == "" {
+			errs.Add("TemplateCommand", i, "Template %s: please enter the backup script", tmpl.Name)
+		} else if ref := QuotedReference(tmpl.Command); ref != "" {
+			errs.Add("TemplateCommand", i, "Template %s: %s expands to a quoted value itself, move it out of the quotes", tmpl.Name, ref)
+		}
+		if tmpl.BackupType != 0 && tmpl.BackupType != 1 {
+			errs.Add("TemplateBackupType", i, "Template %s: unknown backup type %d", tmpl.Name, tmpl.BackupType)
+		}
+		if tmpl.SaveDays < 0 {
+			errs.Add("TemplateSaveDays", i, "Template %s: the local retention days must not be negative", tmpl.Name)
+		}
+		if tmpl.SaveDaysS3 < 0 {
+			errs.Add("TemplateSaveDaysS3", i, "Template %s: the object storage retention days must not be negative", tmpl.Name)
+		}
+	}
+
+	// Secrets are passed in uppercase environment variables, so names must differ in more than case
+	secretNames := make(map[string]string)
+	for i, secret := range conf.Secrets {
+		if !CheckSecretName(secret.Name) {
+			errs.Add("SecretName", i, "Secret name %s may only contain letters, digits and underscores", secret.Name)
+		} else if other, ok := secretNames[strings.ToUpper(secret.Name)]; ok && other == secret.Name {
+			errs.Add("SecretName", i, "Secret %s is defined twice", secret.Name)
+		} else if ok {
+			errs.Add("SecretName", i, "Secret %s only differs in case from secret %s", secret.Name, other)
+		}
+		if _, ok := secretNames[strings.ToUpper(secret.Name)]; !ok {
+			secretNames[strings.ToUpper(secret.Name)] = secret.Name
+		}
+		if secret.Value == "" {
+			errs.Add("SecretValue", i, "Please enter the value of secret %s", secret.Name)
+		}
+	}
+	return errs
+}
+
+// CheckProjectName checks that a project name can be used as a directory name and object key prefix
+func CheckProjectName(name string) error {
+	if strings.TrimSpace(name) != name {
+		return fmt.Errorf("the project name must not start or end with spaces")
+	}
+	if name == "." || strings.Contains(name, "..") {
+		return fmt.Errorf("the project name must not contain ..")
+	}
+	if strings.ContainsAny(name, `/\:*?"<>|`) {
+		return fmt.Errorf(`the project name must not contain any of / \ : * ? " < > |`)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("the project name must not contain control characters")
+		}
+	}
+	return nil
+}
+
+// validate checks the values of a retry policy of a project
+func (policy RetryPolicy) validate(errs *ValidationErrors, prefix string, index int, label string) {
+	if policy.MaxAttempts < 0 {
+		errs.Add(prefix+"MaxAttempts", index, "Project %s: the attempts must not be negative", label)
+	}
+	if policy.InitialDelay < 0 {
+		errs.Add(prefix+"InitialDelay", index, "Project %s: the retry delay must not be negative", label)
+	}
+	if policy.BackoffFactor < 0 {
+		errs.Add(prefix+"BackoffFactor", index, "Project %s: the backoff factor must not be negative", label)
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		errs.Add(prefix+"Jitter", index, "Project %s: the jitter must be between 0 and 1", label)
+	}
+}
@@ -0,0 +1,61 @@
+package entity
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	conf := Config{
+		BackupConfig: []BackupConfig{
+			{ProjectName: "db", Command: "mysqldump", StartTime: 1, Period: 1440, SaveDays: 30},
+			{},
+			{ProjectName: "db", Command: "pg_dump", StartTime: 24, Period: 0, SaveDays: -1},
+			{ProjectName: "../etc", Command: "tar", StartTime: 1, Period: 60, BackupRetry: RetryPolicy{Jitter: 2}},
+			{ProjectName: "files", StartTime: 1, Period: 60, UploadWindows: "25:00-26:00"},
+		},
+		Replicas: []ReplicaTarget{{Name: "dr"}, {Name: "dr", SaveDays: -1}},
+		Secrets:  []Secret{{Name: "TOKEN", Value: "env:TOKEN"}, {Name: "bad-name", Value: "x"}, {Name: "EMPTY"}},
+	}
+
+	expected := []FieldError{
+		{Field: "ProjectName", Index: 2},
+		{Field: "StartTime", Index: 2},
+		{Field: "Period", Index: 2},
+		{Field: "SaveDays", Index: 2},
+		{Field: "ProjectName", Index: 3},
+		{Field: "BackupRetryJitter", Index: 3},
+		{Field: "Command", Index: 4},
+		{Field: "UploadWindows", Index: 4},
+		{Field: "ReplicaName", Index: 1},
+		{Field: "ReplicaSaveDays", Index: 1},
+		{Field: "SecretName", Index: 1},
+		{Field: "SecretValue", Index: 2},
+	}
+	errs := conf.Validate()
+	if len(errs) != len(expected) {
+		t.Fatalf("Validate returned %d errors instead of %d: %+v", len(errs), len(expected), errs)
+	}
+	for i, err := range errs {
+		if err.Field != expected[i].Field || err.Index != expected[i].Index || err.Message == "" {
+			t.Errorf("Error %d not correct: %+v", i, err)
+		}
+	}
+
+	conf.BackupConfig = conf.BackupConfig[:2]
+	conf.Replicas = conf.Replicas[:1]
+	conf.Secrets = conf.Secrets[:1]
+	if errs := conf.Validate(); len(errs) != 0 {
+		t.Errorf("A valid config must not have errors: %v", errs)
+	}
+}
+
+func TestCheckProjectName(t *testing.T) {
+	for _, name := range []string{"db", "my-db_1", "数据库", "db.prod"} {
+		if err := CheckProjectName(name); err != nil {
+			t.Errorf("%s should be valid: %s", name, err)
+		}
+	}
+	for _, name := range []string{"a/b", `a\b`, "..", "a..b", ".", " db", "db\n", "c:"} {
+		if CheckProjectName(name) == nil {
+			t.Errorf("%q should be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package entity
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useTempDir runs a test in a new working directory with an empty configuration cache,
+// restoring both when the test ends
+func useTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	cache.ConfigSingle = nil
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		cache.ConfigSingle = nil
+	})
+}
+
+// memoryS3 is an in-memory S3 stand-in for one bucket, serving objects, paginated listings and multipart uploads
+type memoryS3 struct {
+	lock          sync.Mutex
+	pageSize      int // Keys per ListObjectsV2 page
+	objects       map[string]memoryObject
+	uploads       map[string]*memoryUpload
+	listRequests  int
+	uploadedParts []int // Part numbers received by UploadPart
+}
+
+// memoryObject is an object of memoryS3 with its x-amz-meta- headers
+type memoryObject struct {
+	data     []byte
+	metadata http.Header
+}
+
+// memoryUpload is an unfinished multipart upload of memoryS3
+type memoryUpload struct {
+	key       string
+	metadata  http.Header
+	parts     map[int][]byte
+	initiated time.Time
+}
+
+// newMemoryS3 starts a memoryS3 and returns it with a config using it as bucket "bucket"
+func newMemoryS3(t *testing.T) (*memoryS3, S3Config) {
+	t.Helper()
+	fake := &memoryS3{pageSize: 1000, objects: map[string]memoryObject{}, uploads: map[string]*memoryUpload{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAMEMORY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "memory-secret")
+	return fake, S3Config{Endpoint: server.URL, BucketName: "bucket", Region: "us-east-1", CredentialSource: CredentialEnv}
+}
+
+// putObject stores an object, with the checksum metadata unless checksum is empty
+func (fake *memoryS3) putObject(key string, data []byte, checksum string) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	metadata := http.Header{}
+	if checksum != "" {
+		metadata.Set("X-Amz-Meta-"+checksumMetadataKey, checksum)
+	}
+	fake.objects[key] = memoryObject{data: data, metadata: metadata}
+}
+
+// getObject returns the content of an object and whether it exists
+func (fake *memoryS3) getObject(key string) ([]byte, bool) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	object, ok := fake.objects[key]
+	return object.data, ok
+}
+
+// createUpload starts a multipart upload as an interrupted run would have, returning its id
+func (fake *memoryS3) createUpload(key string, initiated time.Time) string {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	uploadID := fmt.Sprintf("upload-%d", len(fake.uploads)+1)
+	fake.uploads[uploadID] = &memoryUpload{key: key, metadata: http.Header{}, parts: map[int][]byte{}, initiated: initiated}
+	return uploadID
+}
+
+// addPart stores a part of a multipart upload and returns its ETag
+func (fake *memoryS3) addPart(uploadID string, partNumber int, data []byte) string {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	fake.uploads[uploadID].parts[partNumber] = data
+	return etagOf(data)
+}
+
+// hasUpload checks whether a multipart upload is still unfinished
+func (fake *memoryS3) hasUpload(uploadID string) bool {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return fake.uploads[uploadID] != nil
+}
+
+func (fake *memoryS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	// Requests are path style: /bucket/key
+	key := ""
+	if parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2); len(parts) == 2 {
+		key = parts[1]
+	}
+	query := r.URL.Query()
+	_, uploads := query["uploads"]
+	uploadID := query.Get("uploadId")
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case key == "" && query.Get("list-type") == "2":
+		fake.listObjects(w, query)
+	case key == "" && uploads:
+		fake.listUploads(w)
+	case uploads && r.Method == http.MethodPost:
+		uploadID := fmt.Sprintf("upload-%d", len(fake.uploads)+1)
+		fake.uploads[uploadID] = &memoryUpload{key: key, metadata: metadataOf(r.Header), parts: map[int][]byte{}, initiated: time.Now()}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: "bucket", Key: key, UploadId: uploadID})
+	case uploadID != "" && fake.uploads[uploadID] == nil:
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+	case uploadID != "" && r.Method == http.MethodPut:
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		fake.uploads[uploadID].parts[partNumber] = body
+		fake.uploadedParts = append(fake.uploadedParts, partNumber)
+		w.Header().Set("ETag", etagOf(body))
+	case uploadID != "" && r.Method == http.MethodGet:
+		fake.listParts(w, key, uploadID)
+	case uploadID != "" && r.Method == http.MethodPost:
+		fake.completeUpload(w, key, uploadID, body)
+	case uploadID != "" && r.Method == http.MethodDelete:
+		delete(fake.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		fake.objects[key] = memoryObject{data: body, metadata: metadataOf(r.Header)}
+		w.Header().Set("ETag", etagOf(body))
+	case r.Method == http.MethodDelete:
+		delete(fake.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		object, ok := fake.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for name, values := range object.metadata {
+			w.Header()[name] = values
+		}
+		w.Header().Set("ETag", etagOf(object.data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	}
+}
+
+// listObjects answers ListObjectsV2, grouping keys by the delimiter and returning pageSize keys per page
+func (fake *memoryS3) listObjects(w http.ResponseWriter, query map[string][]string) {
+	fake.listRequests++
+	get := func(name string) string {
+		if values := query[name]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	prefix, delimiter, token := get("prefix"), get("delimiter"), get("continuation-token")
+
+	keys := make([]string, 0, len(fake.objects))
+	for key := range fake.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var entries []string
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		entry := key
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			entry = key[:len(prefix)+i+len(delimiter)]
+		}
+		if entry > token && !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	type content struct {
+		Key          string
+		Size         int
+		ETag         string
+		LastModified string
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+		CommonPrefixes        []commonPrefix
+	}{Name: "bucket", Prefix: prefix}
+	if len(entries) > fake.pageSize {
+		entries = entries[:fake.pageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = entries[len(entries)-1]
+	}
+	result.KeyCount = len(entries)
+	for _, entry := range entries {
+		if object, ok := fake.objects[entry]; ok {
+			result.Contents = append(result.Contents, content{Key: entry, Size: len(object.data), ETag: etagOf(object.data),
+				LastModified: "2022-01-01T00:00:00.000Z", StorageClass: "STANDARD"})
+		} else {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+		}
+	}
+	writeXML(w, result)
+}
+
+// listUploads answers ListMultipartUploads in a single page
+func (fake *memoryS3) listUploads(w http.ResponseWriter) {
+	type upload struct {
+		Key       string
+		UploadId  string
+		Initiated string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListMultipartUploadsResult"`
+		Bucket      string
+		IsTruncated bool
+		Upload      []upload
+	}{Bucket: "bucket"}
+	for uploadID, memoryUpload := range fake.uploads {
+		result.Upload = append(result.Upload, upload{Key: memoryUpload.key, UploadId: uploadID, Initiated: memoryUpload.initiated.UTC().Format("2006-01-02T15:04:05.000Z")})
+	}
+	writeXML(w, result)
+}
+
+// listParts answers ListParts in a single page
+func (fake *memoryS3) listParts(w http.ResponseWriter, key string, uploadID string) {
+	type part struct {
+		PartNumber int
+		ETag       string
+		Size       int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListPartsResult"`
+		Bucket      string
+		Key         string
+		UploadId    string
+		IsTruncated bool
+		Part        []part
+	}{Bucket: "bucket", Key: key, UploadId: uploadID}
+	for partNumber, data := range fake.uploads[uploadID].parts {
+		result.Part = append(result.Part, part{PartNumber: partNumber, ETag: etagOf(data), Size: len(data)})
+	}
+	sort.Slice(result.Part, func(i, j int) bool { return result.Part[i].PartNumber < result.Part[j].PartNumber })
+	writeXML(w, result)
+}
+
+// completeUpload joins the listed parts of a multipart upload into the object
+func (fake *memoryS3) completeUpload(w http.ResponseWriter, key string, uploadID string, body []byte) {
+	var request struct {
+		Part []struct {
+			PartNumber int
+			ETag       string
+		}
+	}
+	if err := xml.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	upload := fake.uploads[uploadID]
+	var data []byte
+	for _, part := range request.Part {
+		partData, ok := upload.parts[part.PartNumber]
+		if !ok || etagOf(partData) != part.ETag {
+			writeError(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		data = append(data, partData...)
+	}
+	fake.objects[key] = memoryObject{data: data, metadata: upload.metadata}
+	delete(fake.uploads, uploadID)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: "bucket", Key: key, ETag: etagOf(data)})
+}
+
+// metadataOf returns the x-amz-meta- headers of a request
+func metadataOf(header http.Header) http.Header {
+	metadata := http.Header{}
+	for name, values := range header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			metadata[name] = values
+		}
+	}
+	return metadata
+}
+
+// etagOf returns the quoted MD5 of data, like the ETag of a single part upload
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
@@ -0,0 +1,214 @@
+package entity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// holdFileName stores the held backup files next to the configuration file
+const holdFileName = ".backup_x_holds.yaml"
+
+// pinFilePath is the pin list of older versions, its pins become holds when the holds are read
+var pinFilePath = filepath.Join(parentSavePath, ".backup_x_pins.yaml")
+
+// HoldMarkerSuffix names a sidecar marker that holds the backup file next to it, e.g. 2022-01-01-00-00.sql.hold.
+// The marker may be empty or contain the reason and owner in YAML
+const HoldMarkerSuffix = ".hold"
+
+// Hold exempts a backup file of a project from retention, locally and in object storage, until it is released
+type Hold struct {
+	ProjectName string    `yaml:"projectname,omitempty"`
+	FileName    string    `yaml:"filename,omitempty"`
+	Reason      string    `yaml:"reason,omitempty"`
+	Owner       string    `yaml:"owner,omitempty"`
+	CreatedAt   time.Time `yaml:"createdat,omitempty"`
+	// Marker is set when the hold comes from a sidecar marker instead of the hold list
+	Marker bool `yaml:"-"`
+}
+
+var holdLock sync.Mutex
+
+// GetHolds returns all holds placed through the web UI or API
+func GetHolds() ([]Hold, error) {
+	holdLock.Lock()
+	defer holdLock.Unlock()
+	return readHolds()
+}
+
+// GetHold returns the hold of a backup file from the hold list or its local sidecar marker
+func GetHold(projectName string, fileName string) (Hold, bool) {
+	fileName = filepath.Base(fileName)
+	holds, _ := GetHolds()
+	if hold, held := findHold(holds, projectName, fileName); held {
+		return hold, true
+	}
+
+	markerPath := filepath.Join(getHoldProjectPath(projectName), fileName+HoldMarkerSuffix)
+	info, err := os.Stat(markerPath)
+	if err != nil {
+		return Hold{}, false
+	}
+	hold := ReadHoldMarker(markerPath)
+	hold.ProjectName = projectName
+	hold.FileName = fileName
+	if hold.CreatedAt.IsZero() {
+		hold.CreatedAt = info.ModTime()
+	}
+	return hold, true
+}
+
+// IsHeld checks whether a backup file of a project is held
+func IsHeld(projectName string, fileName string) bool {
+	_, held := GetHold(projectName, fileName)
+	return held
+}
+
+// IsHoldMarker checks whether a file is a sidecar hold marker rather than a backup
+func IsHoldMarker(fileName string) bool {
+	return strings.HasSuffix(fileName, HoldMarkerSuffix)
+}
+
+// ReadHoldMarker reads the reason and owner of a sidecar marker, an empty or invalid marker still holds the file
+func ReadHoldMarker(markerPath string) Hold {
+	hold := Hold{Marker: true}
+	byt, err := ioutil.ReadFile(markerPath)
+	if err == nil {
+		yaml.Unmarshal(byt, &hold)
+	}
+	hold.Marker = true
+	return hold
+}
+
+// PlaceHold holds a backup file, replacing an existing hold of the same file
+func PlaceHold(hold Hold) error {
+	holdLock.Lock()
+	defer holdLock.Unlock()
+
+	holds, err := readHolds()
+	if err != nil {
+		return err
+	}
+
+	hold.FileName = filepath.Base(hold.FileName)
+	hold.Marker = false
+	if hold.CreatedAt.IsZero() {
+		hold.CreatedAt = time.Now()
+	}
+	return writeHolds(append(removeHold(holds, hold.ProjectName, hold.FileName), hold))
+}
+
+// ReleaseHold releases the hold of a backup file and removes its local sidecar marker
+func ReleaseHold(projectName string, fileName string) error {
+	holdLock.Lock()
+	defer holdLock.Unlock()
+
+	holds, err := readHolds()
+	if err != nil {
+		return err
+	}
+
+	fileName = filepath.Base(fileName)
+	err = os.Remove(filepath.Join(getHoldProjectPath(projectName), fileName+HoldMarkerSuffix))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeHolds(removeHold(holds, projectName, fileName))
+}
+
+// removeHold returns the holds without the hold of the given file
+func removeHold(holds []Hold, projectName string, fileName string) []Hold {
+	newHolds := make([]Hold, 0, len(holds)+1)
+	for _, hold := range holds {
+		if hold.ProjectName != projectName || hold.FileName != fileName {
+			newHolds = append(newHolds, hold)
+		}
+	}
+	return newHolds
+}
+
+// readHolds reads the hold file, a missing file means nothing is held
+func readHolds() ([]Hold, error) {
+	holds := make([]Hold, 0)
+	byt, err := ioutil.ReadFile(getHoldFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(byt, &holds); err != nil {
+			return nil, err
+		}
+	}
+	return migratePins(holds)
+}
+
+// migratePins adds the pins of older versions to the holds and removes the pin list once the holds are saved
+func migratePins(holds []Hold) ([]Hold, error) {
+	byt, err := ioutil.ReadFile(pinFilePath)
+	if os.IsNotExist(err) {
+		return holds, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pins []Hold
+	if err := yaml.Unmarshal(byt, &pins); err != nil {
+		return nil, fmt.Errorf("invalid pin file %s: %s", pinFilePath, err)
+	}
+
+	for _, pin := range pins {
+		if _, held := findHold(holds, pin.ProjectName, pin.FileName); held {
+			continue
+		}
+		pin.Reason = "Pinned"
+		holds = append(holds, pin)
+	}
+	if err := writeHolds(holds); err != nil {
+		return nil, err
+	}
+	log.Printf("Moved %d pinned files to the holds\n", len(pins))
+	return holds, os.Remove(pinFilePath)
+}
+
+// findHold returns the hold of a file in holds
+func findHold(holds []Hold, projectName string, fileName string) (Hold, bool) {
+	for _, hold := range holds {
+		if hold.ProjectName == projectName && hold.FileName == fileName {
+			return hold, true
+		}
+	}
+	return Hold{}, false
+}
+
+// writeHolds saves the hold file
+func writeHolds(holds []Hold) error {
+	byt, err := yaml.Marshal(holds)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(getHoldFilePath(), byt, 0600)
+}
+
+// getHoldProjectPath returns the local directory of a project
+func getHoldProjectPath(projectName string) string {
+	conf, _ := GetConfigCache()
+	for _, backupConf := range conf.BackupConfig {
+		if backupConf.ProjectName == projectName {
+			return conf.GetProjectPath(backupConf)
+		}
+	}
+	return conf.GetProjectPath(BackupConfig{ProjectName: projectName})
+}
+
+// getHoldFilePath returns the path to the hold file next to the configuration file
+func getHoldFilePath() string {
+	os.MkdirAll(getDataPath(), 0750)
+	return filepath.Join(getDataPath(), holdFileName)
+}
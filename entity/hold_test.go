@@ -0,0 +1,70 @@
+package entity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestHolds places and releases holds through the hold list and sidecar markers
+func TestHolds(t *testing.T) {
+	useTempDir(t)
+
+	if IsHeld("db", "2022-01-01-00-00.sql") {
+		t.Error("File held without hold")
+	}
+
+	err := PlaceHold(Hold{ProjectName: "db", FileName: "db/2022-01-01-00-00.sql", Reason: "audit", Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold, held := GetHold("db", "2022-01-01-00-00.sql")
+	if !held || hold.Reason != "audit" || hold.Owner != "alice" || hold.CreatedAt.IsZero() {
+		t.Errorf("Hold not correct: %+v", hold)
+	}
+	if IsHeld("other", "2022-01-01-00-00.sql") {
+		t.Error("Hold applies to another project")
+	}
+
+	os.MkdirAll(filepath.Join(parentSavePath, "db"), 0750)
+	marker := filepath.Join(parentSavePath, "db", "2022-01-02-00-00.sql"+HoldMarkerSuffix)
+	os.WriteFile(marker, []byte("reason: legal\nowner: bob\n"), 0600)
+	hold, held = GetHold("db", "2022-01-02-00-00.sql")
+	if !held || !hold.Marker || hold.Reason != "legal" || hold.Owner != "bob" {
+		t.Errorf("Marker hold not correct: %+v", hold)
+	}
+	if !IsHoldMarker(marker) || IsHoldMarker("2022-01-02-00-00.sql") {
+		t.Error("IsHoldMarker not correct")
+	}
+
+	ReleaseHold("db", "2022-01-01-00-00.sql")
+	ReleaseHold("db", "2022-01-02-00-00.sql")
+	if IsHeld("db", "2022-01-01-00-00.sql") || IsHeld("db", "2022-01-02-00-00.sql") {
+		t.Error("Hold not released")
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("Marker not removed on release")
+	}
+}
+
+func TestMigratePins(t *testing.T) {
+	useTempDir(t)
+
+	os.MkdirAll(parentSavePath, 0750)
+	PlaceHold(Hold{ProjectName: "db", FileName: "2022-01-01-00-00.sql", Reason: "audit"})
+	os.WriteFile(pinFilePath, []byte("- projectname: db\n  filename: 2022-01-01-00-00.sql\n- projectname: db\n  filename: 2022-01-02-00-00.sql\n"), 0600)
+
+	holds, err := GetHolds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holds) != 2 || holds[0].Reason != "audit" || holds[1].FileName != "2022-01-02-00-00.sql" || holds[1].Reason != "Pinned" {
+		t.Errorf("Pins not moved to the holds: %+v", holds)
+	}
+	if _, err := os.Stat(pinFilePath); !os.IsNotExist(err) {
+		t.Error("The pin file must be removed once migrated")
+	}
+	if holds, _ := GetHolds(); len(holds) != 2 {
+		t.Errorf("Pins migrated twice: %+v", holds)
+	}
+}
@@ -0,0 +1,217 @@
+package entity
+
+import (
+	"backup-x/util"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Environment variables providing the master key instead of the config file
+const (
+	EncryptKeyEnv    = "BACKUP_X_ENCRYPT_KEY"     // The master key itself
+	NewEncryptKeyEnv = "BACKUP_X_NEW_ENCRYPT_KEY" // The master key to rotate to
+	KeyFileEnv       = "BACKUP_X_KEY_FILE"        // Path to a file holding the master key
+	PassphraseEnv    = "BACKUP_X_PASSPHRASE"      // Passphrase the master key is derived from
+)
+
+// argon2id parameters deriving the master key from a passphrase
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+)
+
+// masterKey is the key loaded at startup, the config file then only stores its KeyID
+var masterKey struct {
+	sync.Mutex
+	key        string
+	passphrase string
+	salt       string // KeySalt of the passphrase
+}
+
+// SetMasterKey uses key, in the format of util.GenerateEncryptKey, as EncryptKey instead of the config file
+func SetMasterKey(key string) error {
+	key = strings.TrimSpace(key)
+	if len(key) != 88 {
+		return errors.New("the master key must be 88 hexadecimal characters")
+	}
+	if _, err := hex.DecodeString(key); err != nil {
+		return errors.New("the master key must be 88 hexadecimal characters")
+	}
+
+	masterKey.Lock()
+	defer masterKey.Unlock()
+	masterKey.key = key
+	masterKey.passphrase = ""
+	return nil
+}
+
+// SetMasterPassphrase derives EncryptKey from a passphrase with argon2id when the config is loaded
+func SetMasterPassphrase(passphrase string) error {
+	if passphrase == "" {
+		return errors.New("the passphrase is empty")
+	}
+
+	masterKey.Lock()
+	defer masterKey.Unlock()
+	masterKey.key = ""
+	masterKey.passphrase = passphrase
+	return nil
+}
+
+// LoadMasterKeyFile reads the master key from a file only its owner can access, creating it with a new key if missing
+func LoadMasterKeyFile(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		key, err := util.GenerateEncryptKey()
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+			return err
+		}
+		return SetMasterKey(key)
+	}
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("key file %s must not be accessible by group or others, run chmod 600 %s", path, path)
+	}
+
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return SetMasterKey(string(byt))
+}
+
+// LoadMasterKeyFromEnv loads the master key from the environment variables, if any is set.
+// The key and the passphrase are removed from the environment, so commands and hooks do not inherit them
+func LoadMasterKeyFromEnv() error {
+	key, passphrase := os.Getenv(EncryptKeyEnv), os.Getenv(PassphraseEnv)
+	os.Unsetenv(EncryptKeyEnv)
+	os.Unsetenv(PassphraseEnv)
+
+	if key != "" {
+		return SetMasterKey(key)
+	}
+	if path := os.Getenv(KeyFileEnv); path != "" {
+		return LoadMasterKeyFile(path)
+	}
+	if passphrase != "" {
+		return SetMasterPassphrase(passphrase)
+	}
+	return nil
+}
+
+// HasMasterKey checks whether a master key or passphrase was provided at startup
+func HasMasterKey() bool {
+	masterKey.Lock()
+	defer masterKey.Unlock()
+	return masterKey.key != "" || masterKey.passphrase != ""
+}
+
+// getMasterKeySalt returns the salt the master key was derived with, if it comes from a passphrase
+func getMasterKeySalt() string {
+	masterKey.Lock()
+	defer masterKey.Unlock()
+	if masterKey.passphrase == "" {
+		return ""
+	}
+	return masterKey.salt
+}
+
+// GetKeyID returns the identifier of an EncryptKey stored in the config instead of the key
+func GetKeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// getMasterKey returns the master key, deriving it from the passphrase and the salt of the config
+func getMasterKey(keySalt string) (key string, salt string, err error) {
+	masterKey.Lock()
+	defer masterKey.Unlock()
+
+	if masterKey.passphrase == "" {
+		return masterKey.key, "", nil
+	}
+	if masterKey.key != "" && masterKey.salt == keySalt {
+		return masterKey.key, masterKey.salt, nil
+	}
+
+	if keySalt == "" {
+		if keySalt, err = newSalt(); err != nil {
+			return "", "", err
+		}
+	}
+	key, err = deriveEncryptKey(masterKey.passphrase, keySalt)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid KeySalt in config: %s", err)
+	}
+	masterKey.key = key
+	masterKey.salt = keySalt
+	return masterKey.key, masterKey.salt, nil
+}
+
+// deriveEncryptKey derives an EncryptKey from a passphrase and a hexadecimal salt with argon2id
+func deriveEncryptKey(passphrase string, salt string) (string, error) {
+	saltBytes, err := hex.DecodeString(salt)
+	if err != nil {
+		return "", err
+	}
+	// 32 bytes of key and 12 bytes of nonce, like util.GenerateEncryptKey
+	derived := argon2.IDKey([]byte(passphrase), saltBytes, argon2Time, argon2Memory, argon2Threads, 44)
+	return hex.EncodeToString(derived), nil
+}
+
+// newSalt returns a random hexadecimal salt
+func newSalt() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
+
+// applyMasterKey replaces the EncryptKey of a loaded config with the master key.
+// A config still holding its own EncryptKey is encrypted again under the master key, and migrated is set
+func (conf *Config) applyMasterKey() (migrated bool, err error) {
+	if !HasMasterKey() {
+		if conf.KeyID != "" && conf.EncryptKey == "" {
+			return false, fmt.Errorf("the config is encrypted with the master key %s, provide it with %s, %s, %s, -keyFile or -passphrase",
+				conf.KeyID, EncryptKeyEnv, KeyFileEnv, PassphraseEnv)
+		}
+		return false, nil
+	}
+
+	key, salt, err := getMasterKey(conf.KeySalt)
+	if err != nil {
+		return false, err
+	}
+
+	if conf.EncryptKey != "" {
+		// The key stored in the config file moves out of it
+		if err := conf.reencrypt(key); err != nil {
+			return false, err
+		}
+		migrated = true
+	} else if conf.KeyID != "" && conf.KeyID != GetKeyID(key) {
+		return false, fmt.Errorf("the master key does not match the key %s the config is encrypted with", conf.KeyID)
+	}
+
+	conf.EncryptKey = key
+	conf.KeyID = GetKeyID(key)
+	conf.KeySalt = salt
+	return migrated, nil
+}
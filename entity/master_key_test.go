@@ -0,0 +1,117 @@
+package entity
+
+import (
+	"backup-x/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// resetMasterKey forgets the master key and the cached config
+func resetMasterKey() {
+	masterKey.Lock()
+	masterKey.key, masterKey.passphrase, masterKey.salt = "", "", ""
+	masterKey.Unlock()
+	cache.ConfigSingle = nil
+}
+
+// TestMasterKeyFile moves the key out of the config into a key file
+func TestMasterKeyFile(t *testing.T) {
+	useTempDir(t)
+	defer resetMasterKey()
+
+	encryptKey, _ := util.GenerateEncryptKey()
+	pwd, _ := util.EncryptByEncryptKey(encryptKey, "db-password")
+	conf := &Config{EncryptKey: encryptKey, BackupConfig: []BackupConfig{{ProjectName: "db", Pwd: pwd}}}
+	conf.SaveConfig()
+
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	if err := LoadMasterKeyFile(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := GetConfigCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plainText, _ := util.DecryptByEncryptKey(loaded.EncryptKey, loaded.BackupConfig[0].Pwd); plainText != "db-password" {
+		t.Errorf("Value not re-encrypted under the master key: %s", plainText)
+	}
+
+	byt, _ := ioutil.ReadFile(getConfigFilePath())
+	if strings.Contains(string(byt), encryptKey) || strings.Contains(string(byt), loaded.EncryptKey) || !strings.Contains(string(byt), loaded.KeyID) {
+		t.Errorf("The config file must only store the key id:\n%s", byt)
+	}
+
+	// Without the master key the config cannot be used
+	resetMasterKey()
+	if _, err := GetConfigCache(); err == nil {
+		t.Error("Loading without the master key must fail")
+	}
+
+	// Another key does not match the key id
+	resetMasterKey()
+	otherKey, _ := util.GenerateEncryptKey()
+	SetMasterKey(otherKey)
+	if _, err := GetConfigCache(); err == nil {
+		t.Error("Loading with another master key must fail")
+	}
+
+	if runtime.GOOS != "windows" {
+		os.Chmod(keyFile, 0644)
+		if err := LoadMasterKeyFile(keyFile); err == nil {
+			t.Error("A key file readable by others must be rejected")
+		}
+	}
+}
+
+// TestMasterPassphrase derives the master key from a passphrase with the salt stored in the config
+func TestMasterPassphrase(t *testing.T) {
+	useTempDir(t)
+	defer resetMasterKey()
+
+	SetMasterPassphrase("correct horse")
+	conf, _ := GetConfigCache()
+	pwd, _ := util.EncryptByEncryptKey(conf.EncryptKey, "db-password")
+	conf.BackupConfig = []BackupConfig{{ProjectName: "db", Pwd: pwd}}
+	if err := conf.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	resetMasterKey()
+	SetMasterPassphrase("correct horse")
+	loaded, err := GetConfigCache()
+	if err != nil || loaded.KeySalt == "" {
+		t.Fatalf("Loading with the passphrase failed: %v", err)
+	}
+	if plainText, _ := util.DecryptByEncryptKey(loaded.EncryptKey, loaded.BackupConfig[0].Pwd); plainText != "db-password" {
+		t.Errorf("Derived key not correct: %s", plainText)
+	}
+
+	resetMasterKey()
+	SetMasterPassphrase("wrong horse")
+	if _, err := GetConfigCache(); err == nil {
+		t.Error("Loading with a wrong passphrase must fail")
+	}
+}
+
+func TestLoadMasterKeyFromEnv(t *testing.T) {
+	defer resetMasterKey()
+	key, _ := util.GenerateEncryptKey()
+	t.Setenv(EncryptKeyEnv, key)
+	t.Setenv(PassphraseEnv, "correct horse")
+
+	if err := LoadMasterKeyFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if masterKey.key != key {
+		t.Error("Master key not loaded from the environment")
+	}
+	for _, name := range []string{EncryptKeyEnv, PassphraseEnv} {
+		if _, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s must be removed from the environment", name)
+		}
+	}
+}
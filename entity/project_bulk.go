@@ -0,0 +1,187 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Storage targets of a project besides the names of the replica targets
+const (
+	StorageTargetDefault = ""      // The primary object storage, copied to every replica target
+	StorageTargetLocal   = "local" // Keep the backups on this host only
+)
+
+// ProjectSelector selects projects by id, group and tags. A project matches when it has one of the ids,
+// or, without ids, is in the group and has all tags. Text matches the name, group or a tag
+type ProjectSelector struct {
+	IDs   []string
+	Group string
+	Tags  []string
+	Text  string
+}
+
+// BulkUpdate are the settings changed on all selected projects, nil fields are kept
+type BulkUpdate struct {
+	Enabled       *int
+	SaveDays      *int
+	SaveDaysS3    *int
+	StorageTarget *string
+}
+
+// Empty checks whether nothing is selected
+func (selector ProjectSelector) Empty() bool {
+	return len(selector.IDs) == 0 && selector.Group == "" && len(selector.Tags) == 0 && selector.Text == ""
+}
+
+// Matches checks whether a project is selected
+func (selector ProjectSelector) Matches(backupConf BackupConfig) bool {
+	if len(selector.IDs) > 0 {
+		for _, id := range selector.IDs {
+			if id == backupConf.ID {
+				return true
+			}
+		}
+		return false
+	}
+	if selector.Group != "" && selector.Group != backupConf.Group {
+		return false
+	}
+	for _, tag := range selector.Tags {
+		if !backupConf.HasTag(tag) {
+			return false
+		}
+	}
+	if selector.Text != "" {
+		text := strings.ToLower(selector.Text)
+		found := strings.Contains(strings.ToLower(backupConf.ProjectName), text) || strings.Contains(strings.ToLower(backupConf.Group), text)
+		for _, tag := range backupConf.Tags {
+			found = found || strings.Contains(strings.ToLower(tag), text)
+		}
+		return found
+	}
+	return true
+}
+
+// SelectProjects returns the selected projects, all projects for an empty selector
+func (conf *Config) SelectProjects(selector ProjectSelector) (projects []BackupConfig) {
+	for _, backupConf := range conf.BackupConfig {
+		if selector.Matches(backupConf) {
+			projects = append(projects, backupConf)
+		}
+	}
+	return projects
+}
+
+// BulkUpdateProjects changes the settings of the selected projects and saves the configuration.
+// It returns the ids of the changed projects
+func BulkUpdateProjects(selector ProjectSelector, update BulkUpdate, author string) (ids []string, err error) {
+	if selector.Empty() {
+		return nil, errors.New("no projects selected")
+	}
+	conf, err := GetConfigCache()
+	if err != nil {
+		return nil, err
+	}
+
+	conf.BackupConfig = append([]BackupConfig(nil), conf.BackupConfig...)
+	for i := range conf.BackupConfig {
+		backupConf := &conf.BackupConfig[i]
+		if !selector.Matches(*backupConf) {
+			continue
+		}
+		if update.Enabled != nil {
+			backupConf.Enabled = *update.Enabled
+		}
+		if update.SaveDays != nil {
+			backupConf.SaveDays = *update.SaveDays
+		}
+		if update.SaveDaysS3 != nil {
+			backupConf.SaveDaysS3 = *update.SaveDaysS3
+		}
+		if update.StorageTarget != nil {
+			backupConf.StorageTarget = *update.StorageTarget
+		}
+		ids = append(ids, backupConf.ID)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no projects match the selection")
+	}
+
+	if errs := conf.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	return ids, conf.SaveConfigBy(author, fmt.Sprintf("Bulk update of %d projects", len(ids)))
+}
+
+// HasTag checks whether the project has a tag
+func (backupConfig *BackupConfig) HasTag(tag string) bool {
+	for _, t := range backupConfig.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTags splits comma separated tags, removing spaces, empty and repeated tags
+func ParseTags(text string) (tags []string) {
+	for _, tag := range strings.Split(text, ",") {
+		tags = appendUnique(tags, strings.TrimSpace(tag))
+	}
+	return tags
+}
+
+// GetTags returns the tags and groups of all projects
+func (conf *Config) GetTags() (tags []string, groups []string) {
+	for _, backupConf := range conf.BackupConfig {
+		for _, tag := range backupConf.Tags {
+			tags = appendUnique(tags, tag)
+		}
+		groups = appendUnique(groups, backupConf.Group)
+	}
+	return tags, groups
+}
+
+// appendUnique appends a value that is not empty and not in the list yet
+func appendUnique(list []string, value string) []string {
+	if value == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// CheckStorageTarget checks that a storage target is the default, local or a replica target
+func (conf *Config) CheckStorageTarget(target string) bool {
+	if target == StorageTargetDefault || target == StorageTargetLocal {
+		return true
+	}
+	for _, replica := range conf.Replicas {
+		if replica.Name == target {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUploadTarget returns the object storage a backup of the project is uploaded to,
+// and whether it is then copied to the replica targets. ok is false when the backup is not uploaded
+func (conf *Config) GetUploadTarget(backupConf BackupConfig) (s3Config S3Config, replicate bool, ok bool) {
+	switch backupConf.StorageTarget {
+	case StorageTargetDefault:
+		return conf.S3Config.ForProject(backupConf), true, conf.S3Config.CheckNotEmpty()
+	case StorageTargetLocal:
+		return s3Config, false, false
+	}
+	for _, replica := range conf.Replicas {
+		if replica.Name == backupConf.StorageTarget {
+			return replica.ForProject(backupConf).S3Config, false, replica.CheckNotEmpty()
+		}
+	}
+	return s3Config, false, false
+}
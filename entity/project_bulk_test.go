@@ -0,0 +1,100 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProjectSelector(t *testing.T) {
+	conf := Config{BackupConfig: []BackupConfig{
+		{ID: "1", ProjectName: "orders", Group: "shop", Tags: []string{"prod", "mysql"}},
+		{ID: "2", ProjectName: "orders-test", Group: "shop", Tags: []string{"test", "mysql"}},
+		{ID: "3", ProjectName: "wiki", Tags: []string{"prod"}},
+	}}
+	selected := func(selector ProjectSelector) (ids []string) {
+		for _, backupConf := range conf.SelectProjects(selector) {
+			ids = append(ids, backupConf.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		selector ProjectSelector
+		ids      []string
+	}{
+		{ProjectSelector{}, []string{"1", "2", "3"}},
+		{ProjectSelector{Tags: []string{"prod"}}, []string{"1", "3"}},
+		{ProjectSelector{Tags: []string{"prod", "mysql"}}, []string{"1"}},
+		{ProjectSelector{Group: "shop"}, []string{"1", "2"}},
+		{ProjectSelector{Text: "ORDERS"}, []string{"1", "2"}},
+		{ProjectSelector{Text: "sho", Tags: []string{"test"}}, []string{"2"}},
+		{ProjectSelector{IDs: []string{"3"}, Group: "shop"}, []string{"3"}},
+		{ProjectSelector{Group: "none"}, nil},
+	}
+	for _, test := range tests {
+		if ids := selected(test.selector); !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%+v selected %v instead of %v", test.selector, ids, test.ids)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	if tags := ParseTags(" prod, mysql,,prod "); !reflect.DeepEqual(tags, []string{"prod", "mysql"}) {
+		t.Errorf("ParseTags not correct: %v", tags)
+	}
+	if tags := ParseTags(""); tags != nil {
+		t.Errorf("ParseTags not correct: %v", tags)
+	}
+}
+
+func TestBulkUpdateProjects(t *testing.T) {
+	useTempDir(t)
+
+	conf := &Config{EncryptKey: "key", Replicas: []ReplicaTarget{{Name: "dr"}}, BackupConfig: []BackupConfig{
+		{ProjectName: "orders", Command: "mysqldump", Period: 60, SaveDays: 30, Tags: []string{"prod"}},
+		{ProjectName: "wiki", Command: "tar", Period: 60, SaveDays: 30},
+	}}
+	conf.SaveConfig()
+
+	if _, err := BulkUpdateProjects(ProjectSelector{}, BulkUpdate{}, "admin"); err == nil {
+		t.Error("An empty selection must be rejected")
+	}
+
+	disabled, saveDays, target := 1, 7, "dr"
+	ids, err := BulkUpdateProjects(ProjectSelector{Tags: []string{"prod"}}, BulkUpdate{Enabled: &disabled, SaveDays: &saveDays, StorageTarget: &target}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := GetConfigCache()
+	if len(ids) != 1 || ids[0] != saved.BackupConfig[0].ID {
+		t.Errorf("Changed projects not correct: %v", ids)
+	}
+	orders, wiki := saved.BackupConfig[0], saved.BackupConfig[1]
+	if orders.Enabled != 1 || orders.SaveDays != 7 || orders.SaveDaysS3 != 0 || orders.StorageTarget != "dr" {
+		t.Errorf("Project not changed: %+v", orders)
+	}
+	if wiki.Enabled != 0 || wiki.SaveDays != 30 || wiki.StorageTarget != "" {
+		t.Errorf("Project not selected was changed: %+v", wiki)
+	}
+
+	unknown := "missing"
+	if _, err := BulkUpdateProjects(ProjectSelector{Tags: []string{"prod"}}, BulkUpdate{StorageTarget: &unknown}, "admin"); err == nil {
+		t.Error("An unknown storage target must be rejected")
+	}
+}
+
+func TestGetUploadTarget(t *testing.T) {
+	primary := S3Config{Endpoint: "https://s3.example.com", AccessKey: "a", SecretKey: "s", BucketName: "primary"}
+	replica := S3Config{Endpoint: "https://s3.example.com", AccessKey: "a", SecretKey: "s", BucketName: "dr"}
+	conf := Config{S3Config: primary, Replicas: []ReplicaTarget{{Name: "dr", S3Config: replica}}}
+
+	if s3Config, replicate, ok := conf.GetUploadTarget(BackupConfig{}); !ok || !replicate || s3Config.BucketName != "primary" {
+		t.Error("The default target must be the primary object storage")
+	}
+	if s3Config, replicate, ok := conf.GetUploadTarget(BackupConfig{StorageTarget: "dr"}); !ok || replicate || s3Config.BucketName != "dr" {
+		t.Error("A replica target must be used instead of the primary object storage")
+	}
+	if _, _, ok := conf.GetUploadTarget(BackupConfig{StorageTarget: StorageTargetLocal}); ok {
+		t.Error("Local backups must not be uploaded")
+	}
+}
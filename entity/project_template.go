@@ -0,0 +1,236 @@
+package entity
+
+import (
+	"backup-x/util"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TemplateParamNames are the parameters a project template can use as #{host}, #{port}, #{user},
+// #{database}, #{path} and #{remote}. The password is not a parameter, templates use #{PWD}
+var TemplateParamNames = []string{"host", "port", "user", "database", "path", "remote"}
+
+// TemplateParams are the values of the parameters of a project template
+type TemplateParams struct {
+	Host     string
+	Port     string
+	User     string
+	Database string
+	Path     string // File or directory on this host
+	Remote   string // Remote of rclone, e.g. remote:bucket/dir
+}
+
+// ProjectTemplate generates the backup script and retention of a new project
+type ProjectTemplate struct {
+	Name        string
+	Description string
+	Command     string         // Backup script, the parameters are replaced when a project is created from the template
+	BackupType  int            // Backup type: 0 = Database backup, 1 = File sync
+	SaveDays    int            // Number of days to keep local backups
+	SaveDaysS3  int            // Number of days to keep backups in object storage (S3)
+	Defaults    TemplateParams // Used for parameters that are not entered
+	BuiltIn     bool           `yaml:"-"`
+}
+
+// builtinTemplates are shipped with backup-x and cannot be changed. Passwords are passed in environment variables
+// or, for mongodump, a config file on a pipe, so they are not in the process list
+var builtinTemplates = []ProjectTemplate{
+	{
+		Name:        "mysql",
+		Description: "MySQL / MariaDB database with mysqldump",
+		Command:     "MYSQL_PWD=#{PWD} mysqldump -h #{host} -P #{port} -u #{user} --single-transaction --routines --events #{database} | gzip > #{DATE}.sql.gz",
+		SaveDays:    30,
+		SaveDaysS3:  60,
+		Defaults:    TemplateParams{Host: "127.0.0.1", Port: "3306", User: "root"},
+	},
+	{
+		Name:        "postgresql",
+		Description: "PostgreSQL database with pg_dump in the custom format",
+		Command:     "PGPASSWORD=#{PWD} pg_dump -h #{host} -p #{port} -U #{user} -Fc -f #{DATE}.dump #{database}",
+		SaveDays:    30,
+		SaveDaysS3:  60,
+		Defaults:    TemplateParams{Host: "127.0.0.1", Port: "5432", User: "postgres"},
+	},
+	{
+		Name:        "mongodb",
+		Description: "MongoDB database with mongodump as a compressed archive",
+		Command:     "mongodump --config <(printf 'password: |-\\n  %s\\n' #{PWD}) --host #{host} --port #{port} --username #{user} --authenticationDatabase admin --db #{database} --gzip --archive=#{DATE}.archive.gz",
+		SaveDays:    30,
+		SaveDaysS3:  60,
+		Defaults:    TemplateParams{Host: "127.0.0.1", Port: "27017", User: "root"},
+	},
+	{
+		Name:        "redis",
+		Description: "Redis RDB snapshot with redis-cli",
+		Command:     "REDISCLI_AUTH=#{PWD} redis-cli -h #{host} -p #{port} --rdb #{DATE}.rdb",
+		SaveDays:    7,
+		SaveDaysS3:  30,
+		Defaults:    TemplateParams{Host: "127.0.0.1", Port: "6379"},
+	},
+	{
+		Name:        "sqlite",
+		Description: "SQLite database file with the online backup of sqlite3",
+		Command:     "sqlite3 #{path} \".backup #{DATE}.db\" && gzip #{DATE}.db",
+		SaveDays:    30,
+		SaveDaysS3:  60,
+	},
+	{
+		Name:        "directory",
+		Description: "Directory as a gzip compressed tarball",
+		Command:     "tar -czf #{DATE}.tar.gz -C #{path} .",
+		SaveDays:    7,
+		SaveDaysS3:  30,
+	},
+	{
+		Name:        "rclone",
+		Description: "Directory synced to a remote of rclone",
+		Command:     "rclone sync #{path} #{remote}",
+		BackupType:  1,
+		SaveDays:    7,
+		SaveDaysS3:  30,
+	},
+}
+
+// BuiltinTemplates returns the templates shipped with backup-x
+func BuiltinTemplates() []ProjectTemplate {
+	templates := make([]ProjectTemplate, len(builtinTemplates))
+	for i, tmpl := range builtinTemplates {
+		tmpl.BuiltIn = true
+		templates[i] = tmpl
+	}
+	return templates
+}
+
+// GetTemplates returns the built-in templates followed by the user-defined templates
+func (conf *Config) GetTemplates() []ProjectTemplate {
+	return append(BuiltinTemplates(), conf.Templates...)
+}
+
+// GetTemplate returns the built-in or user-defined template with the name
+func (conf *Config) GetTemplate(name string) (ProjectTemplate, bool) {
+	for _, tmpl := range conf.GetTemplates() {
+		if tmpl.Name == name {
+			return tmpl, true
+		}
+	}
+	return ProjectTemplate{}, false
+}
+
+// isBuiltinTemplate checks whether a template name is used by a built-in template
+func isBuiltinTemplate(name string) bool {
+	for _, tmpl := range builtinTemplates {
+		if tmpl.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Params returns the names of the parameters the template uses
+func (tmpl ProjectTemplate) Params() (names []string) {
+	for _, name := range TemplateParamNames {
+		if strings.Contains(tmpl.Command, "#{"+name+"}") || strings.Contains(tmpl.Command, "#{"+name+"|") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Render returns a project with the backup script and retention of the template.
+// Parameters that are not entered use the defaults of the template, every used parameter is required.
+// Values are quoted for the shell unless the parameter asks for another escape mode, e.g. #{database|raw}
+func (tmpl ProjectTemplate) Render(params TemplateParams) (BackupConfig, error) {
+	values := tmpl.Defaults.values()
+	for name, value := range params.values() {
+		if value = strings.TrimSpace(value); value != "" {
+			values[name] = value
+		}
+	}
+
+	command := tmpl.Command
+	for _, name := range tmpl.Params() {
+		value := strings.TrimSpace(values[name])
+		if value == "" {
+			return BackupConfig{}, fmt.Errorf("please enter the %s of template %s", name, tmpl.Name)
+		}
+		command = strings.ReplaceAll(command, "#{"+name+"}", quoteTemplateParam(value))
+		for _, mode := range []string{util.EscapeModeRaw, util.EscapeModeShell, util.EscapeModeURL, util.EscapeModeJSON} {
+			command = strings.ReplaceAll(command, "#{"+name+"|"+mode+"}", util.EscapeValue(value, mode))
+		}
+	}
+	return BackupConfig{Command: command, BackupType: tmpl.BackupType, SaveDays: tmpl.SaveDays, SaveDaysS3: tmpl.SaveDaysS3}, nil
+}
+
+// values returns the parameters by name
+func (params TemplateParams) values() map[string]string {
+	return map[string]string{
+		"host":     params.Host,
+		"port":     params.Port,
+		"user":     params.User,
+		"database": params.Database,
+		"path":     params.Path,
+		"remote":   params.Remote,
+	}
+}
+
+// quoteTemplateParam quotes a parameter for the shell unless it only has characters that need no quotes
+func quoteTemplateParam(value string) string {
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.,:/@%+=", r)) {
+			return util.QuoteShell(value)
+		}
+	}
+	return value
+}
+
+// SaveTemplate adds a user-defined template, or replaces the one with the same name, and saves the configuration
+func SaveTemplate(tmpl ProjectTemplate, author string) error {
+	conf, err := GetConfigCache()
+	if err != nil {
+		return err
+	}
+
+	tmpl.BuiltIn = false
+	templates := make([]ProjectTemplate, 0, len(conf.Templates)+1)
+	replaced := false
+	for _, t := range conf.Templates {
+		if t.Name == tmpl.Name {
+			t = tmpl
+			replaced = true
+		}
+		templates = append(templates, t)
+	}
+	if !replaced {
+		templates = append(templates, tmpl)
+	}
+	conf.Templates = templates
+
+	if errs := conf.Validate(); len(errs) > 0 {
+		return errs
+	}
+	return conf.SaveConfigBy(author, "Saved template "+tmpl.Name)
+}
+
+// DeleteTemplate removes a user-defined template and saves the configuration
+func DeleteTemplate(name string, author string) error {
+	if isBuiltinTemplate(name) {
+		return errors.New("built-in templates cannot be deleted")
+	}
+	conf, err := GetConfigCache()
+	if err != nil {
+		return err
+	}
+
+	templates := make([]ProjectTemplate, 0, len(conf.Templates))
+	for _, tmpl := range conf.Templates {
+		if tmpl.Name != name {
+			templates = append(templates, tmpl)
+		}
+	}
+	if len(templates) == len(conf.Templates) {
+		return fmt.Errorf("template %s not found", name)
+	}
+	conf.Templates = templates
+	return conf.SaveConfigBy(author, "Deleted template "+name)
+}
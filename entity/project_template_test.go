@@ -0,0 +1,73 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTemplateRender(t *testing.T) {
+	conf := Config{}
+	mysql, ok := conf.GetTemplate("mysql")
+	if !ok || !mysql.BuiltIn {
+		t.Fatal("The built-in mysql template is missing")
+	}
+	if params := mysql.Params(); !reflect.DeepEqual(params, []string{"host", "port", "user", "database"}) {
+		t.Errorf("Params not correct: %v", params)
+	}
+
+	project, err := mysql.Render(TemplateParams{Host: "db.local", Database: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "MYSQL_PWD=#{PWD} mysqldump -h db.local -P 3306 -u root --single-transaction --routines --events shop | gzip > #{DATE}.sql.gz"
+	if project.Command != expected || project.SaveDays != 30 || project.SaveDaysS3 != 60 {
+		t.Errorf("Render not correct: %+v", project)
+	}
+
+	if _, err := mysql.Render(TemplateParams{Host: "db.local"}); err == nil {
+		t.Error("A missing database must be rejected")
+	}
+
+	tmpl := ProjectTemplate{Name: "files", Command: "tar -czf #{DATE}.tar.gz #{path} && echo #{path|raw}"}
+	project, err = tmpl.Render(TemplateParams{Path: "/srv/my files"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Command != "tar -czf #{DATE}.tar.gz '/srv/my files' && echo /srv/my files" {
+		t.Errorf("Parameters not quoted: %s", project.Command)
+	}
+}
+
+func TestSaveTemplate(t *testing.T) {
+	useTempDir(t)
+
+	conf := &Config{EncryptKey: "key"}
+	conf.SaveConfig()
+
+	if err := SaveTemplate(ProjectTemplate{Name: "mysql", Command: "mysqldump"}, "admin"); err == nil {
+		t.Error("The name of a built-in template must be rejected")
+	}
+	if err := SaveTemplate(ProjectTemplate{Name: "pg-replica", Command: "pg_dump -h #{host}", SaveDays: 7}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveTemplate(ProjectTemplate{Name: "pg-replica", Command: "pg_dump -h #{host} -p #{port}", SaveDays: 14}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := GetConfigCache()
+	if len(saved.Templates) != 1 || saved.Templates[0].SaveDays != 14 {
+		t.Fatalf("Template not replaced: %+v", saved.Templates)
+	}
+	if tmpl, ok := saved.GetTemplate("pg-replica"); !ok || tmpl.BuiltIn || len(tmpl.Params()) != 2 {
+		t.Errorf("User-defined template not found: %+v", tmpl)
+	}
+
+	if err := DeleteTemplate("mysql", "admin"); err == nil {
+		t.Error("Built-in templates must not be deleted")
+	}
+	if err := DeleteTemplate("pg-replica", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := GetConfigCache(); len(saved.Templates) != 0 {
+		t.Errorf("Template not deleted: %+v", saved.Templates)
+	}
+}
@@ -1,8 +1,102 @@
 package entity
 
+import (
+	"backup-x/util"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type BackupResult struct {
-	ProjectName string 
+	ProjectName string
 	FileName    string
 	FileSize    string
 	Result      string
+	Attempts    []AttemptRecord
+	Upload      UploadResult
+	Replicas    []ReplicaResult
+	Hooks       []HookResult
+	RunID       string        // Unique id of the run
+	StartTime   time.Time     // Start of the run
+	Duration    time.Duration // Duration of the run so far
+	FilePath    string        // Local path of the backup file
+	Err         string        // Error that failed the run
+}
+
+// HookResult records the outcome of running a hook command
+type HookResult struct {
+	Hook     string // pre, post-success, post-failure or cleanup
+	Result   string // Success or Failed
+	Duration time.Duration
+	Output   string // Output of the hook, truncated
+	Err      string
+}
+
+// HookSummary summarizes the hook results, e.g. "pre: Success, cleanup: Failed"
+func (result BackupResult) HookSummary() string {
+	summary := make([]string, 0, len(result.Hooks))
+	for _, hook := range result.Hooks {
+		summary = append(summary, hook.Hook+": "+hook.Result)
+	}
+	return strings.Join(summary, ", ")
+}
+
+// UploadResult records the outcome of uploading the backup file to S3
+type UploadResult struct {
+	Result   string // Success, Failed, Skipped or Queued until an upload window opens
+	Bytes    int64
+	Duration time.Duration
+	ETag     string
+	Checksum string // SHA-256 of the uploaded file
+	Err      string
+}
+
+// ReplicaSummary summarizes the replication results, e.g. "dr: Success, offsite: Failed"
+func (result BackupResult) ReplicaSummary() string {
+	summary := make([]string, 0, len(result.Replicas))
+	for _, replica := range result.Replicas {
+		summary = append(summary, replica.Name+": "+replica.Result)
+	}
+	return strings.Join(summary, ", ")
+}
+
+// CountAttempts returns the number of attempts made for the given step
+func (result BackupResult) CountAttempts(step string) (count int) {
+	for _, attempt := range result.Attempts {
+		if attempt.Step == step {
+			count++
+		}
+	}
+	return
+}
+
+// TemplateContext returns the run context available to commands and webhooks as #{name}
+func (result BackupResult) TemplateContext() util.TemplateContext {
+	hostName, _ := os.Hostname()
+	return util.TemplateContext{
+		Time: result.StartTime,
+		Values: map[string]string{
+			"projectName":    result.ProjectName,
+			"fileName":       result.FileName,
+			"filePath":       result.FilePath,
+			"fileSize":       result.FileSize,
+			"result":         result.Result,
+			"error":          result.Err,
+			"runId":          result.RunID,
+			"hostName":       hostName,
+			"duration":       result.Duration.Round(time.Millisecond).String(),
+			"backupAttempts": strconv.Itoa(result.CountAttempts(StepBackup)),
+			"uploadAttempts": strconv.Itoa(result.CountAttempts(StepUpload)),
+			"uploadResult":   result.Upload.Result,
+			"uploadSize":     fmt.Sprintf("%d MB", result.Upload.Bytes/1000/1000),
+			"uploadDuration": result.Upload.Duration.Round(time.Millisecond).String(),
+			"uploadETag":     result.Upload.ETag,
+			"uploadError":    result.Upload.Err,
+			"checksum":       result.Upload.Checksum,
+			"replicaResult":  result.ReplicaSummary(),
+			"hookResult":     result.HookSummary(),
+		},
+	}
 }
@@ -0,0 +1,45 @@
+package entity
+
+import (
+	"backup-x/util"
+	"time"
+)
+
+// Steps of a backup run that can be retried
+const (
+	StepBackup = "backup"
+	StepUpload = "upload"
+)
+
+// RetryPolicy describes how a failed step is retried
+type RetryPolicy struct {
+	MaxAttempts   int     // Maximum number of attempts, including the first one
+	InitialDelay  int     // Delay before the first retry (seconds)
+	BackoffFactor float64 // Multiplier applied to the delay after each retry
+	Jitter        float64 // Random jitter ratio (0-1) applied to each delay
+}
+
+// DefaultRetryPolicy is used for new projects
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialDelay: 60, BackoffFactor: 2, Jitter: 0.2}
+
+// AttemptRecord records the outcome of a single attempt
+type AttemptRecord struct {
+	Step     string // backup or upload
+	Attempt  int
+	Start    time.Time
+	Duration time.Duration
+	Err      string
+}
+
+// Attempts returns the number of attempts allowed, at least 1
+func (policy RetryPolicy) Attempts() int {
+	if policy.MaxAttempts < 1 {
+		return 1
+	}
+	return policy.MaxAttempts
+}
+
+// Delay returns the wait time before the given retry (1-based)
+func (policy RetryPolicy) Delay(retry int) time.Duration {
+	return util.BackoffDelay(time.Duration(policy.InitialDelay)*time.Second, policy.BackoffFactor, policy.Jitter, retry)
+}
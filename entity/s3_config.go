@@ -2,13 +2,22 @@ package entity
 
 import (
 	"backup-x/util"
+	"crypto/md5"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -16,19 +25,56 @@ import (
 
 // S3Config holds S3 object storage configuration
 type S3Config struct {
-	Endpoint   string
-	AccessKey  string
-	SecretKey  string
-	BucketName string
-	Region     string
+	Endpoint        string
+	AccessKey       string
+	SecretKey       string
+	BucketName      string
+	Region          string
+	PartSize        int    // Multipart upload part size (MB)
+	Concurrency     int    // Number of parts uploaded in parallel
+	MaxUploadKBps   int    // Global upload bandwidth limit (KB/s), 0 = unlimited
+	MaxDownloadKBps int    // Global download bandwidth limit (KB/s), 0 = unlimited
+	UploadWindows   string // Daily windows when uploads are allowed, e.g. 22:00-06:00
+
+	ServerSideEncryption string // Server-side encryption: empty, AES256 or aws:kms
+	SSEKMSKeyID          string // KMS key id used with aws:kms
+	StorageClass         string // Storage class of uploaded objects, e.g. STANDARD_IA, GLACIER, DEEP_ARCHIVE
+	ObjectLockMode       string // Object Lock retention mode: empty, GOVERNANCE or COMPLIANCE
+
+	CredentialSource      string // Where credentials come from, see the Credential* constants
+	Profile               string // Profile in the shared credentials file
+	SharedCredentialsFile string // Shared credentials file, defaults to ~/.aws/credentials
+	WebIdentityTokenFile  string // Web identity token file used with CredentialWebIdentity
+	RoleARN               string // Role to assume with the selected credentials
+	ExternalID            string // External ID used when assuming RoleARN
+	RoleSessionName       string // Session name used when assuming RoleARN
+	STSEndpoint           string // STS endpoint, defaults to AWS STS
+
+	// The project using this config, see ForProject
+	project *BackupConfig
 }
 
+// rateLimiters holds the shared limiters so that each limit applies across concurrent transfers
+var rateLimiters = struct {
+	sync.Mutex
+	kbps     map[string]int
+	limiters map[string]*util.RateLimiter
+}{kbps: map[string]int{}, limiters: map[string]*util.RateLimiter{}}
+
 var ErrS3Empty = errors.New("S3 config is empty")
 
+// checksumMetadataKey is the object metadata holding the SHA-256 checksum of the backup file
+const checksumMetadataKey = "sha256"
+
 // CheckNotEmpty checks if the S3 configuration is complete
 func (s3Config S3Config) CheckNotEmpty() bool {
-	return s3Config.Endpoint != "" && s3Config.AccessKey != "" &&
-		s3Config.SecretKey != "" && s3Config.BucketName != ""
+	if s3Config.Endpoint == "" || s3Config.BucketName == "" {
+		return false
+	}
+	if s3Config.usesStaticCredentials() {
+		return s3Config.AccessKey != "" && s3Config.SecretKey != ""
+	}
+	return true
 }
 
 // getSession creates an AWS session for S3 operations
@@ -38,22 +84,6 @@ func (s3Config S3Config) getSession() (*session.Session, error) {
 		return nil, ErrS3Empty
 	}
 
-	conf, err := GetConfigCache()
-	if err != nil {
-		return nil, err
-	}
-
-	secretKey, err := util.DecryptByEncryptKey(conf.EncryptKey, s3Config.SecretKey)
-	if err != nil {
-		return nil, err
-	}
-
-	creds := credentials.NewStaticCredentials(s3Config.AccessKey, secretKey, "")
-	_, err = creds.Get()
-	if err != nil {
-		log.Println(err)
-	}
-
 	region := "cn-north-1"
 	// Use the configured region if provided
 	if s3Config.Region != "" {
@@ -65,6 +95,11 @@ func (s3Config S3Config) getSession() (*session.Session, error) {
 		}
 	}
 
+	creds, err := s3Config.getCredentials(region)
+	if err != nil {
+		return nil, err
+	}
+
 	config := &aws.Config{
 		Region:           aws.String(region),
 		Endpoint:         aws.String(s3Config.Endpoint),
@@ -74,9 +109,90 @@ func (s3Config S3Config) getSession() (*session.Session, error) {
 	}
 
 	mySession, err := session.NewSession(config)
+	if err == nil {
+		if limiters := s3Config.getLimiters("upload", s3Config.MaxUploadKBps); len(limiters) > 0 {
+			mySession.Handlers.Send.PushFrontNamed(request.NamedHandler{Name: "backupx.ThrottleUpload", Fn: throttleRequestBody(limiters)})
+		}
+		if limiters := s3Config.getLimiters("download", s3Config.MaxDownloadKBps); len(limiters) > 0 {
+			mySession.Handlers.Send.PushBackNamed(request.NamedHandler{Name: "backupx.ThrottleDownload", Fn: throttleResponseBody(limiters)})
+		}
+	}
 	return mySession, err
 }
 
+// ForProject returns a copy of the config that also applies the project's bandwidth limits and retention
+func (s3Config S3Config) ForProject(backupConf BackupConfig) S3Config {
+	s3Config.project = &backupConf
+	return s3Config
+}
+
+// getLimiters returns the global and project rate limiters for a direction
+func (s3Config S3Config) getLimiters(direction string, globalKBps int) (limiters []*util.RateLimiter) {
+	if limiter := getRateLimiter(direction, globalKBps); limiter != nil {
+		limiters = append(limiters, limiter)
+	}
+	if s3Config.project != nil {
+		projectKBps := s3Config.project.MaxUploadKBps
+		if direction == "download" {
+			projectKBps = s3Config.project.MaxDownloadKBps
+		}
+		if limiter := getRateLimiter(direction+"/"+s3Config.project.ProjectName, projectKBps); limiter != nil {
+			limiters = append(limiters, limiter)
+		}
+	}
+	return
+}
+
+// getRateLimiter returns the shared limiter with the given name, nil if unlimited
+func getRateLimiter(name string, kbps int) *util.RateLimiter {
+	if kbps <= 0 {
+		return nil
+	}
+
+	rateLimiters.Lock()
+	defer rateLimiters.Unlock()
+	if rateLimiters.limiters[name] == nil || rateLimiters.kbps[name] != kbps {
+		rateLimiters.kbps[name] = kbps
+		rateLimiters.limiters[name] = util.NewRateLimiter(int64(kbps) * 1024)
+	}
+	return rateLimiters.limiters[name]
+}
+
+// throttleRequestBody returns a handler limiting the bandwidth used by request bodies
+func throttleRequestBody(limiters []*util.RateLimiter) func(*request.Request) {
+	return func(r *request.Request) {
+		body := r.HTTPRequest.Body
+		if body != nil && body != http.NoBody {
+			r.HTTPRequest.Body = struct {
+				io.Reader
+				io.Closer
+			}{limitReader(body, limiters), body}
+		}
+	}
+}
+
+// throttleResponseBody returns a handler limiting the bandwidth used by response bodies
+func throttleResponseBody(limiters []*util.RateLimiter) func(*request.Request) {
+	return func(r *request.Request) {
+		if r.Error != nil || r.HTTPResponse == nil || r.HTTPResponse.Body == nil {
+			return
+		}
+		body := r.HTTPResponse.Body
+		r.HTTPResponse.Body = struct {
+			io.Reader
+			io.Closer
+		}{limitReader(body, limiters), body}
+	}
+}
+
+// limitReader wraps reader with every limiter
+func limitReader(reader io.Reader, limiters []*util.RateLimiter) io.Reader {
+	for _, limiter := range limiters {
+		reader = limiter.Reader(reader)
+	}
+	return reader
+}
+
 // CreateBucketIfNotExist creates the bucket if it does not exist
 func (s3Config S3Config) CreateBucketIfNotExist() {
 	mySession, err := s3Config.getSession()
@@ -97,6 +213,9 @@ func (s3Config S3Config) CreateBucketIfNotExist() {
 		create := &s3.CreateBucketInput{
 			Bucket: aws.String(s3Config.BucketName),
 		}
+		if s3Config.ObjectLockMode != "" {
+			create.ObjectLockEnabledForBucket = aws.Bool(true)
+		}
 		_, err = client.CreateBucket(create)
 		if err != nil {
 			log.Printf("Failed to create bucket: %s, ERR: %s\n", s3Config.BucketName, err)
@@ -106,8 +225,19 @@ func (s3Config S3Config) CreateBucketIfNotExist() {
 	}
 }
 
-// UploadFile uploads a file to the S3 bucket
-func (s3Config S3Config) UploadFile(fileName string) {
+// UploadFile uploads a local file to the S3 bucket as the object key and verifies the uploaded size
+func (s3Config S3Config) UploadFile(fileName string, key string) (result UploadResult, err error) {
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+		if err != nil {
+			result.Result = "Failed"
+			result.Err = err.Error()
+		} else {
+			result.Result = "Success"
+		}
+	}()
+
 	mySession, err := s3Config.getSession()
 	if err != nil {
 		if err != ErrS3Empty {
@@ -125,45 +255,276 @@ func (s3Config S3Config) UploadFile(fileName string) {
 	}
 	defer file.Close()
 
-	uploader := s3manager.NewUploader(mySession)
-	_, err = uploader.Upload(&s3manager.UploadInput{
+	info, err := file.Stat()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// The checksum is stored with the object so copies can be verified
+	result.Checksum, err = util.FileSha256(fileName)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	svc := s3.New(mySession)
+	var etag string
+	if info.Size() < s3Config.getPartSize(info.Size()) {
+		input := &s3.PutObjectInput{
+			Bucket:   aws.String(s3Config.BucketName),
+			Key:      aws.String(key),
+			Body:     file,
+			Metadata: checksumMetadata(result.Checksum),
+		}
+		s3Config.applyObjectOptions(input)
+		if s3Config.ObjectLockMode != "" {
+			// Object Lock requires Content-MD5
+			input.ContentMD5, err = contentMD5(file)
+		}
+		var output *s3.PutObjectOutput
+		if err == nil {
+			output, err = svc.PutObject(input)
+		}
+		if err == nil {
+			etag = aws.StringValue(output.ETag)
+		}
+	} else {
+		etag, err = s3Config.uploadMultipart(svc, file, info, key, result.Checksum)
+	}
+	if err != nil {
+		log.Printf("Failed to upload %s to S3. ERR: %s \n", fileName, err)
+		return
+	}
+	result.ETag = strings.Trim(etag, "\"")
+
+	// Verify the object stored in S3
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
 		Bucket: aws.String(s3Config.BucketName),
-		Key:    aws.String(fileName),
-		Body:   file,
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		log.Printf("Failed to upload %s to S3. ERR: %s \n", fileName, err)
-	} else {
-		log.Printf("%s successfully uploaded to S3\n", fileName)
+		log.Printf("Failed to verify %s in S3. ERR: %s \n", fileName, err)
+		return
 	}
+	if aws.Int64Value(head.ContentLength) != info.Size() {
+		err = fmt.Errorf("%s size in S3 is %d bytes, expected %d bytes", fileName, aws.Int64Value(head.ContentLength), info.Size())
+		log.Println(err)
+		return
+	}
+
+	result.Bytes = info.Size()
+	log.Printf("%s successfully uploaded to S3\n", fileName)
+	return
+}
+
+// applyObjectOptions sets encryption, storage class and Object Lock options on an upload request.
+// input is a *s3.PutObjectInput, *s3.CreateMultipartUploadInput, *s3.CopyObjectInput or *s3manager.UploadInput.
+func (s3Config S3Config) applyObjectOptions(input interface{}) {
+	var lockMode *string
+	var retainUntil *time.Time
+	if s3Config.ObjectLockMode != "" && s3Config.project != nil && s3Config.project.SaveDaysS3 > 0 {
+		lockMode = aws.String(s3Config.ObjectLockMode)
+		retainUntil = aws.Time(time.Now().AddDate(0, 0, s3Config.project.SaveDaysS3))
+	}
+	set := func(encryption, kmsKeyID, storageClass, mode **string, until **time.Time) {
+		*encryption = optionalString(s3Config.ServerSideEncryption)
+		*kmsKeyID = optionalString(s3Config.SSEKMSKeyID)
+		*storageClass = optionalString(s3Config.StorageClass)
+		*mode, *until = lockMode, retainUntil
+	}
+
+	switch in := input.(type) {
+	case *s3.PutObjectInput:
+		set(&in.ServerSideEncryption, &in.SSEKMSKeyId, &in.StorageClass, &in.ObjectLockMode, &in.ObjectLockRetainUntilDate)
+	case *s3.CreateMultipartUploadInput:
+		set(&in.ServerSideEncryption, &in.SSEKMSKeyId, &in.StorageClass, &in.ObjectLockMode, &in.ObjectLockRetainUntilDate)
+	case *s3manager.UploadInput:
+		set(&in.ServerSideEncryption, &in.SSEKMSKeyId, &in.StorageClass, &in.ObjectLockMode, &in.ObjectLockRetainUntilDate)
+	case *s3.CopyObjectInput:
+		set(&in.ServerSideEncryption, &in.SSEKMSKeyId, &in.StorageClass, &in.ObjectLockMode, &in.ObjectLockRetainUntilDate)
+	}
+}
+
+// checksumMetadata returns the object metadata holding the SHA-256 checksum
+func checksumMetadata(checksum string) map[string]*string {
+	return map[string]*string{checksumMetadataKey: aws.String(checksum)}
+}
+
+// getChecksum returns the SHA-256 checksum stored in the object metadata
+func getChecksum(metadata map[string]*string) string {
+	for key, value := range metadata {
+		if strings.EqualFold(key, checksumMetadataKey) {
+			return aws.StringValue(value)
+		}
+	}
+	return ""
+}
+
+// optionalString returns nil for an empty string so the header is not sent
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return aws.String(value)
+}
+
+// contentMD5 returns the base64 MD5 of the reader's content and rewinds it
+func contentMD5(reader io.ReadSeeker) (*string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return nil, err
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return aws.String(base64.StdEncoding.EncodeToString(hash.Sum(nil))), nil
+}
+
+// IsLocked reports whether an object is protected by an Object Lock retention or legal hold.
+// An error means the lock could not be read, the object must then be treated as locked
+func (s3Config S3Config) IsLocked(key string) (bool, error) {
+	mySession, err := s3Config.getSession()
+	if err != nil {
+		return false, err
+	}
+
+	svc := s3.New(mySession)
+	retention, err := svc.GetObjectRetention(&s3.GetObjectRetentionInput{
+		Bucket: aws.String(s3Config.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil && !isNoObjectLockError(err) {
+		return false, err
+	}
+	if err == nil && retention.Retention != nil && retention.Retention.RetainUntilDate != nil &&
+		retention.Retention.RetainUntilDate.After(time.Now()) {
+		return true, nil
+	}
+
+	legalHold, err := svc.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{
+		Bucket: aws.String(s3Config.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil && !isNoObjectLockError(err) {
+		return false, err
+	}
+	if err == nil && legalHold.LegalHold != nil && aws.StringValue(legalHold.LegalHold.Status) == s3.ObjectLockLegalHoldStatusOn {
+		return true, nil
+	}
+	return false, nil
+}
+
+// isNoObjectLockError checks whether an error only says that the bucket or object has no Object Lock settings
+func isNoObjectLockError(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "InvalidRequest", "ObjectLockConfigurationNotFoundError", "NoSuchObjectLockConfiguration", "NotImplemented":
+			return true
+		}
+	}
+	return false
+}
+
+// PresignDownloadURL returns a pre-signed URL to download an object
+func (s3Config S3Config) PresignDownloadURL(key string, expire time.Duration) (string, error) {
+	mySession, err := s3Config.getSession()
+	if err != nil {
+		return "", err
+	}
+
+	req, _ := s3.New(mySession).GetObjectRequest(&s3.GetObjectInput{
+		Bucket:                     aws.String(s3Config.BucketName),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String("attachment; filename=\"" + path.Base(key) + "\""),
+	})
+	return req.Presign(expire)
+}
+
+// HasDownloadLimit checks whether downloads are limited by the config or by the project given to ForProject
+func (s3Config S3Config) HasDownloadLimit() bool {
+	return s3Config.MaxDownloadKBps > 0 || (s3Config.project != nil && s3Config.project.MaxDownloadKBps > 0)
+}
+
+// GetChecksum returns the SHA-256 checksum stored with an object, empty if it has none
+func (s3Config S3Config) GetChecksum(key string) (string, error) {
+	mySession, err := s3Config.getSession()
+	if err != nil {
+		return "", err
+	}
+
+	head, err := s3.New(mySession).HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s3Config.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	return getChecksum(head.Metadata), nil
 }
 
-// ListFiles lists files in the S3 bucket under a project path
-func (s3Config S3Config) ListFiles(projectPath string) (fileNames []string, err error) {
+// DownloadFile writes an object of the S3 bucket to writer, limited to the download bandwidth of the config and project
+func (s3Config S3Config) DownloadFile(key string, writer io.Writer) (n int64, err error) {
 	mySession, err := s3Config.getSession()
 	if err != nil {
 		if err != ErrS3Empty {
 			log.Printf("Failed to create S3 session, ERR: %s\n", err)
 		}
-		return nil, err
+		return 0, err
 	}
 
-	svc := s3.New(mySession)
-	params := &s3.ListObjectsInput{
+	output, err := s3.New(mySession).GetObject(&s3.GetObjectInput{
 		Bucket: aws.String(s3Config.BucketName),
-		Prefix: aws.String(projectPath),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, err
 	}
+	defer output.Body.Close()
+
+	return io.Copy(writer, output.Body)
+}
 
-	resp, err := svc.ListObjects(params)
+// S3Object describes an object stored in the S3 bucket
+type S3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	StorageClass string
+	ETag         string
+}
+
+// ListFiles lists the objects directly under a prefix such as BackupConfig.GetS3Prefix(), following pagination
+func (s3Config S3Config) ListFiles(prefix string) (objects []S3Object, err error) {
+	mySession, err := s3Config.getSession()
 	if err != nil {
+		if err != ErrS3Empty {
+			log.Printf("Failed to create S3 session, ERR: %s\n", err)
+		}
 		return nil, err
 	}
 
-	for _, item := range resp.Contents {
-		fileNames = append(fileNames, *item.Key)
+	svc := s3.New(mySession)
+	params := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s3Config.BucketName),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
 	}
 
-	return fileNames, err
+	err = svc.ListObjectsV2Pages(params, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, item := range page.Contents {
+			objects = append(objects, S3Object{
+				Key:          aws.StringValue(item.Key),
+				Size:         aws.Int64Value(item.Size),
+				LastModified: aws.TimeValue(item.LastModified),
+				StorageClass: aws.StringValue(item.StorageClass),
+				ETag:         strings.Trim(aws.StringValue(item.ETag), "\""),
+			})
+		}
+		return true
+	})
+
+	return objects, err
 }
 
 // DeleteFile deletes a file from the S3 bucket
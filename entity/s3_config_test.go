@@ -0,0 +1,34 @@
+package entity
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestListFilesPagination(t *testing.T) {
+	fake, s3Config := newMemoryS3(t)
+	fake.pageSize = 2
+	for day := 1; day <= 5; day++ {
+		fake.putObject(fmt.Sprintf("backup-x-files/db/2022-01-0%d.sql", day), []byte("dump"), "")
+	}
+	fake.putObject("backup-x-files/db2/2022-01-01.sql", []byte("other project"), "")
+	fake.putObject("backup-x-files/db/nested/2022-01-01.sql", []byte("nested"), "")
+
+	backupConf := BackupConfig{ProjectName: "db"}
+	objects, err := s3Config.ListFiles(backupConf.GetS3Prefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 5 {
+		t.Fatalf("Objects of all pages not listed: %+v", objects)
+	}
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, "backup-x-files/db/") || strings.Contains(object.Key, "nested") || object.Size != 4 {
+			t.Errorf("Object of another project or directory listed: %+v", object)
+		}
+	}
+	if fake.listRequests < 3 {
+		t.Errorf("Listing not paginated: %d requests", fake.listRequests)
+	}
+}
@@ -0,0 +1,100 @@
+package entity
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Credential sources of S3Config.CredentialSource
+const (
+	CredentialStatic      = "static"       // AccessKey/SecretKey stored in the config
+	CredentialEnv         = "env"          // AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY environment variables
+	CredentialShared      = "shared"       // Shared credentials file and profile
+	CredentialWebIdentity = "web-identity" // Web identity token exchanged for RoleARN
+	CredentialInstance    = "instance"     // EC2 instance role or ECS task role
+	CredentialChain       = "chain"        // Default chain: environment, shared file, instance role
+)
+
+const defaultRoleSessionName = "backup-x"
+
+// usesStaticCredentials reports whether the keys stored in the config are used
+func (s3Config S3Config) usesStaticCredentials() bool {
+	return s3Config.CredentialSource == "" || s3Config.CredentialSource == CredentialStatic
+}
+
+// getCredentials returns the credentials selected by CredentialSource,
+// assuming RoleARN with them when configured
+func (s3Config S3Config) getCredentials(region string) (*credentials.Credentials, error) {
+	var creds *credentials.Credentials
+	switch s3Config.CredentialSource {
+	case "", CredentialStatic:
+		conf, err := GetConfigCache()
+		if err != nil {
+			return nil, err
+		}
+		secretKey, err := conf.ResolveSecret(s3Config.SecretKey)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewStaticCredentials(s3Config.AccessKey, secretKey, "")
+	case CredentialEnv:
+		creds = credentials.NewEnvCredentials()
+	case CredentialShared:
+		creds = credentials.NewSharedCredentials(s3Config.SharedCredentialsFile, s3Config.Profile)
+	case CredentialWebIdentity:
+		if s3Config.RoleARN == "" || s3Config.WebIdentityTokenFile == "" {
+			return nil, fmt.Errorf("web identity credentials require RoleARN and WebIdentityTokenFile")
+		}
+		stsSession, err := s3Config.getSTSSession(region, credentials.AnonymousCredentials)
+		if err != nil {
+			return nil, err
+		}
+		return stscreds.NewWebIdentityCredentials(stsSession, s3Config.RoleARN, s3Config.getRoleSessionName(), s3Config.WebIdentityTokenFile), nil
+	case CredentialInstance:
+		creds = credentials.NewCredentials(defaults.RemoteCredProvider(*defaults.Config(), defaults.Handlers()))
+	case CredentialChain:
+		creds = defaults.CredChain(defaults.Config().WithRegion(region), defaults.Handlers())
+	default:
+		return nil, fmt.Errorf("unknown S3 credential source: %s", s3Config.CredentialSource)
+	}
+
+	if s3Config.RoleARN == "" {
+		return creds, nil
+	}
+
+	stsSession, err := s3Config.getSTSSession(region, creds)
+	if err != nil {
+		return nil, err
+	}
+	return stscreds.NewCredentials(stsSession, s3Config.RoleARN, func(provider *stscreds.AssumeRoleProvider) {
+		provider.RoleSessionName = s3Config.getRoleSessionName()
+		if s3Config.ExternalID != "" {
+			provider.ExternalID = aws.String(s3Config.ExternalID)
+		}
+	}), nil
+}
+
+// getSTSSession creates the session used to call STS
+func (s3Config S3Config) getSTSSession(region string, creds *credentials.Credentials) (*session.Session, error) {
+	config := &aws.Config{
+		Region:      aws.String(region),
+		Credentials: creds,
+	}
+	if s3Config.STSEndpoint != "" {
+		config.Endpoint = aws.String(s3Config.STSEndpoint)
+	}
+	return session.NewSession(config)
+}
+
+// getRoleSessionName returns the session name used when assuming a role
+func (s3Config S3Config) getRoleSessionName() string {
+	if s3Config.RoleSessionName != "" {
+		return s3Config.RoleSessionName
+	}
+	return defaultRoleSessionName
+}
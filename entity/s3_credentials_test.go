@@ -0,0 +1,109 @@
+package entity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const listBucketResponse = `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>bucket</Name><KeyCount>0</KeyCount><IsTruncated>false</IsTruncated></ListBucketResult>`
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIATEMPORARY</AccessKeyId>
+      <SecretAccessKey>temporary-secret</SecretAccessKey>
+      <SessionToken>temporary-token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser><Arn>arn:aws:sts::123456789012:assumed-role/backup/backup-x</Arn><AssumedRoleId>ID:backup-x</AssumedRoleId></AssumedRoleUser>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+// fakeS3 is a minimal S3 and STS stand-in recording the requests it receives
+type fakeS3 struct {
+	lock           sync.Mutex
+	authorizations []string
+	externalIDs    []string
+}
+
+func (fake *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	r.ParseForm()
+	if r.Method == http.MethodPost && r.PostForm.Get("Action") == "AssumeRole" {
+		fake.externalIDs = append(fake.externalIDs, r.PostForm.Get("ExternalId"))
+		w.Write([]byte(assumeRoleResponse))
+		return
+	}
+	fake.authorizations = append(fake.authorizations, r.Header.Get("Authorization"))
+	w.Write([]byte(listBucketResponse))
+}
+
+func (fake *fakeS3) lastAuthorization() string {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if len(fake.authorizations) == 0 {
+		return ""
+	}
+	return fake.authorizations[len(fake.authorizations)-1]
+}
+
+func TestEnvCredentials(t *testing.T) {
+	fake := &fakeS3{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAENVIRONMENT")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "environment-secret")
+	s3Config := S3Config{Endpoint: server.URL, BucketName: "bucket", Region: "us-east-1", CredentialSource: CredentialEnv}
+	if !s3Config.CheckNotEmpty() {
+		t.Fatal("S3 config without static keys should not be empty")
+	}
+	if _, err := s3Config.ListFiles("backup-x-files/db/"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(fake.lastAuthorization(), "Credential=AKIAENVIRONMENT/") {
+		t.Error("Environment credentials were not used")
+	}
+}
+
+func TestAssumeRoleWithExternalID(t *testing.T) {
+	fake := &fakeS3{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	os.WriteFile(credentialsFile, []byte("[backup]\naws_access_key_id = AKIASHARED\naws_secret_access_key = shared-secret\n"), 0600)
+	s3Config := S3Config{
+		Endpoint:              server.URL,
+		BucketName:            "bucket",
+		Region:                "us-east-1",
+		CredentialSource:      CredentialShared,
+		SharedCredentialsFile: credentialsFile,
+		Profile:               "backup",
+		RoleARN:               "arn:aws:iam::123456789012:role/backup",
+		ExternalID:            "external-id",
+		STSEndpoint:           server.URL,
+	}
+	if _, err := s3Config.ListFiles("backup-x-files/db/"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.externalIDs) != 1 || fake.externalIDs[0] != "external-id" {
+		t.Error("Role was not assumed with the external ID")
+	}
+	if !strings.Contains(fake.lastAuthorization(), "Credential=ASIATEMPORARY/") {
+		t.Error("Assumed role credentials were not used")
+	}
+}
+
+func TestUnknownCredentialSource(t *testing.T) {
+	s3Config := S3Config{Endpoint: "http://127.0.0.1:1", BucketName: "bucket", CredentialSource: "unknown"}
+	if _, err := s3Config.ListFiles("backup-x-files/db/"); err == nil {
+		t.Error("Unknown credential source should fail")
+	}
+}
@@ -0,0 +1,323 @@
+package entity
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// multipartStateDir stores the state of unfinished multipart uploads
+const multipartStateDir = ".multipart_uploads"
+
+// minPartSize is the smallest part size accepted by S3
+const minPartSize = 5 * 1024 * 1024
+
+// maxParts is the largest number of parts in a single multipart upload
+const maxParts = 10000
+
+// defaultConcurrency is the number of parts uploaded in parallel by default
+const defaultConcurrency = 4
+
+// abandonedUploadAge is the age after which unfinished multipart uploads are aborted
+const abandonedUploadAge = 3 * 24 * time.Hour
+
+// multipartState is persisted so an interrupted upload resumes from the last completed part
+type multipartState struct {
+	Bucket   string
+	Key      string
+	FilePath string // Absolute path of the local file being uploaded
+	UploadID string
+	PartSize int64
+	FileSize int64
+	ModTime  time.Time
+	Parts    []multipartPart
+}
+
+// multipartPart is a completed part of a multipart upload
+type multipartPart struct {
+	PartNumber int64
+	ETag       string
+	Size       int64
+}
+
+// getPartSize returns the part size for a file, large enough to stay within maxParts
+func (s3Config S3Config) getPartSize(fileSize int64) int64 {
+	partSize := int64(s3Config.PartSize) * 1024 * 1024
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+	for fileSize/partSize >= maxParts {
+		partSize *= 2
+	}
+	return partSize
+}
+
+// getConcurrency returns the number of parts uploaded in parallel
+func (s3Config S3Config) getConcurrency() int {
+	if s3Config.Concurrency <= 0 {
+		return defaultConcurrency
+	}
+	return s3Config.Concurrency
+}
+
+// uploadMultipart uploads the file in parts, resuming a previously interrupted upload when possible
+func (s3Config S3Config) uploadMultipart(svc *s3.S3, file *os.File, info os.FileInfo, key string, checksum string) (etag string, err error) {
+	partSize := s3Config.getPartSize(info.Size())
+	statePath := getMultipartStatePath(key)
+
+	state := s3Config.resumeMultipartState(svc, statePath, info, partSize)
+	if state == nil {
+		input := &s3.CreateMultipartUploadInput{
+			Bucket:   aws.String(s3Config.BucketName),
+			Key:      aws.String(key),
+			Metadata: checksumMetadata(checksum),
+		}
+		s3Config.applyObjectOptions(input)
+		output, err := svc.CreateMultipartUpload(input)
+		if err != nil {
+			return "", err
+		}
+		filePath, _ := filepath.Abs(file.Name())
+		state = &multipartState{
+			Bucket:   s3Config.BucketName,
+			Key:      key,
+			FilePath: filePath,
+			UploadID: aws.StringValue(output.UploadId),
+			PartSize: partSize,
+			FileSize: info.Size(),
+			ModTime:  info.ModTime(),
+		}
+		if err = state.save(statePath); err != nil {
+			log.Printf("Failed to save multipart upload state of %s, ERR: %s\n", key, err)
+		}
+	} else {
+		log.Printf("Resuming upload of %s, %d parts already uploaded\n", key, len(state.Parts))
+	}
+
+	completed := make(map[int64]bool)
+	for _, part := range state.Parts {
+		completed[part.PartNumber] = true
+	}
+
+	partCount := (info.Size() + partSize - 1) / partSize
+	parts := make(chan int64, partCount)
+	for partNumber := int64(1); partNumber <= partCount; partNumber++ {
+		if !completed[partNumber] {
+			parts <- partNumber
+		}
+	}
+	close(parts)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var uploadErr error
+	for i := 0; i < s3Config.getConcurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range parts {
+				lock.Lock()
+				failed := uploadErr != nil
+				lock.Unlock()
+				if failed {
+					return
+				}
+
+				offset := (partNumber - 1) * partSize
+				size := partSize
+				if offset+size > info.Size() {
+					size = info.Size() - offset
+				}
+				input := &s3.UploadPartInput{
+					Bucket:        aws.String(s3Config.BucketName),
+					Key:           aws.String(key),
+					UploadId:      aws.String(state.UploadID),
+					PartNumber:    aws.Int64(partNumber),
+					ContentLength: aws.Int64(size),
+					Body:          io.NewSectionReader(file, offset, size),
+				}
+				var output *s3.UploadPartOutput
+				var err error
+				if s3Config.ObjectLockMode != "" {
+					// Object Lock requires Content-MD5 for every part
+					input.ContentMD5, err = contentMD5(input.Body)
+				}
+				if err == nil {
+					output, err = svc.UploadPart(input)
+				}
+
+				lock.Lock()
+				if err != nil {
+					if uploadErr == nil {
+						uploadErr = fmt.Errorf("failed to upload part %d of %s: %s", partNumber, key, err)
+					}
+				} else {
+					state.Parts = append(state.Parts, multipartPart{PartNumber: partNumber, ETag: aws.StringValue(output.ETag), Size: size})
+					if err := state.save(statePath); err != nil {
+						log.Printf("Failed to save multipart upload state of %s, ERR: %s\n", key, err)
+					}
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if uploadErr != nil {
+		// Keep the state so the next attempt resumes from the completed parts
+		return "", uploadErr
+	}
+
+	sort.Slice(state.Parts, func(i, j int) bool { return state.Parts[i].PartNumber < state.Parts[j].PartNumber })
+	completedParts := make([]*s3.CompletedPart, 0, len(state.Parts))
+	for _, part := range state.Parts {
+		completedParts = append(completedParts, &s3.CompletedPart{PartNumber: aws.Int64(part.PartNumber), ETag: aws.String(part.ETag)})
+	}
+	output, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s3Config.BucketName),
+		Key:             aws.String(key),
+		UploadId:        aws.String(state.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completedParts},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	os.Remove(statePath)
+	return aws.StringValue(output.ETag), nil
+}
+
+// resumeMultipartState returns the saved state of an interrupted upload of the same file,
+// keeping only the parts S3 still has. It returns nil when the upload cannot be resumed.
+func (s3Config S3Config) resumeMultipartState(svc *s3.S3, statePath string, info os.FileInfo, partSize int64) *multipartState {
+	state, err := loadMultipartState(statePath)
+	if err != nil {
+		return nil
+	}
+	if state.Bucket != s3Config.BucketName || state.FileSize != info.Size() ||
+		!state.ModTime.Equal(info.ModTime()) || state.PartSize != partSize {
+		// The file changed since the upload started
+		abortMultipartUpload(svc, state.Bucket, state.Key, state.UploadID)
+		os.Remove(statePath)
+		return nil
+	}
+
+	uploaded := make(map[int64]string)
+	err = svc.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			uploaded[aws.Int64Value(part.PartNumber)] = aws.StringValue(part.ETag)
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Unable to resume upload of %s, starting again. ERR: %s\n", state.Key, err)
+		os.Remove(statePath)
+		return nil
+	}
+
+	parts := make([]multipartPart, 0, len(state.Parts))
+	for _, part := range state.Parts {
+		if uploaded[part.PartNumber] == part.ETag {
+			parts = append(parts, part)
+		}
+	}
+	state.Parts = parts
+	return state
+}
+
+// AbortAbandonedUploads aborts unfinished multipart uploads whose local file is gone, so they cannot be resumed.
+// Uploads without a state file, like replication, and uploads of older versions, whose state has no local file,
+// are aborted after abandonedUploadAge
+func (s3Config S3Config) AbortAbandonedUploads() {
+	mySession, err := s3Config.getSession()
+	if err != nil {
+		if err != ErrS3Empty {
+			log.Printf("Failed to create S3 session, ERR: %s\n", err)
+		}
+		return
+	}
+
+	svc := s3.New(mySession)
+	err = svc.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s3Config.BucketName),
+		Prefix: aws.String(parentSavePath + "/"),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, upload := range page.Uploads {
+			key := aws.StringValue(upload.Key)
+			statePath := getMultipartStatePath(key)
+			state, err := loadMultipartState(statePath)
+			hasState := err == nil && state.Bucket == s3Config.BucketName && state.UploadID == aws.StringValue(upload.UploadId)
+			if hasState && state.FilePath != "" {
+				if _, err := os.Stat(state.FilePath); err == nil {
+					continue
+				}
+			} else if time.Since(aws.TimeValue(upload.Initiated)) < abandonedUploadAge {
+				// Replication keeps no state and may still be running
+				continue
+			}
+			if abortMultipartUpload(svc, s3Config.BucketName, key, aws.StringValue(upload.UploadId)) && hasState {
+				os.Remove(statePath)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to list unfinished multipart uploads, ERR: %s\n", err)
+	}
+}
+
+// abortMultipartUpload aborts a multipart upload and reports whether it succeeded
+func abortMultipartUpload(svc *s3.S3, bucket string, key string, uploadID string) bool {
+	_, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		log.Printf("Failed to abort multipart upload of %s, ERR: %s\n", key, err)
+		return false
+	}
+	log.Printf("Aborted unfinished multipart upload of %s\n", key)
+	return true
+}
+
+// getMultipartStatePath returns the path of the state file for an object key
+func getMultipartStatePath(key string) string {
+	dir := filepath.Join(getDataPath(), multipartStateDir)
+	os.MkdirAll(dir, 0750)
+	return filepath.Join(dir, fmt.Sprintf("%s-%x.json", filepath.Base(key), sha1.Sum([]byte(key))))
+}
+
+// loadMultipartState reads a saved multipart upload state
+func loadMultipartState(statePath string) (*multipartState, error) {
+	byt, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		return nil, err
+	}
+	state := &multipartState{}
+	err = json.Unmarshal(byt, state)
+	return state, err
+}
+
+// save writes the multipart upload state to disk
+func (state *multipartState) save(statePath string) error {
+	byt, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(statePath, byt, 0600)
+}
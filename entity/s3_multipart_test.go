@@ -0,0 +1,74 @@
+package entity
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestResumeMultipartUpload(t *testing.T) {
+	useTempDir(t)
+	fake, s3Config := newMemoryS3(t)
+
+	// The state of an interrupted upload lists two of three parts, but S3 only kept the first one
+	data := bytes.Repeat([]byte("backup-x"), (2*minPartSize+1024)/8)
+	os.WriteFile("db.sql", data, 0600)
+	info, _ := os.Stat("db.sql")
+	filePath, _ := filepath.Abs("db.sql")
+	key := "backup-x-files/db/db.sql"
+	uploadID := fake.createUpload(key, time.Now())
+	state := multipartState{
+		Bucket: "bucket", Key: key, FilePath: filePath, UploadID: uploadID,
+		PartSize: minPartSize, FileSize: info.Size(), ModTime: info.ModTime(),
+		Parts: []multipartPart{
+			{PartNumber: 1, ETag: fake.addPart(uploadID, 1, data[:minPartSize]), Size: minPartSize},
+			{PartNumber: 2, ETag: etagOf(data[minPartSize : 2*minPartSize]), Size: minPartSize},
+		},
+	}
+	statePath := getMultipartStatePath(key)
+	state.save(statePath)
+
+	result, err := s3Config.UploadFile("db.sql", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(fake.uploadedParts)
+	if len(fake.uploadedParts) != 2 || fake.uploadedParts[0] != 2 || fake.uploadedParts[1] != 3 {
+		t.Errorf("Only the parts S3 does not have must be uploaded: %v", fake.uploadedParts)
+	}
+	if uploaded, _ := fake.getObject(key); !bytes.Equal(uploaded, data) || result.Bytes != info.Size() {
+		t.Errorf("Object not uploaded correctly: %d bytes", len(uploaded))
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Error("The state must be removed after the upload")
+	}
+}
+
+func TestAbortAbandonedUploads(t *testing.T) {
+	useTempDir(t)
+	fake, s3Config := newMemoryS3(t)
+
+	// A replication in progress keeps no state, an old one without state was abandoned
+	running := fake.createUpload("backup-x-files/db/running.sql", time.Now())
+	abandoned := fake.createUpload("backup-x-files/db/abandoned.sql", time.Now().Add(-abandonedUploadAge-time.Hour))
+
+	// The local file of an upload with state is gone
+	key := "backup-x-files/db/removed.sql"
+	removed := fake.createUpload(key, time.Now())
+	state := multipartState{Bucket: "bucket", Key: key, FilePath: filepath.Join(t.TempDir(), "removed.sql"), UploadID: removed}
+	state.save(getMultipartStatePath(key))
+
+	s3Config.AbortAbandonedUploads()
+	if !fake.hasUpload(running) {
+		t.Error("A recent upload without state must not be aborted")
+	}
+	if fake.hasUpload(abandoned) || fake.hasUpload(removed) {
+		t.Error("Abandoned uploads must be aborted")
+	}
+	if _, err := os.Stat(getMultipartStatePath(key)); !os.IsNotExist(err) {
+		t.Error("The state of an aborted upload must be removed")
+	}
+}
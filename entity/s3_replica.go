@@ -0,0 +1,213 @@
+package entity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// maxCopyObjectSize is the largest object copied with a single CopyObject request
+const maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// copyPartSize is the part size used to copy larger objects
+const copyPartSize = 512 * 1024 * 1024
+
+// ReplicaTarget is a secondary S3-compatible target receiving a copy of every backup
+type ReplicaTarget struct {
+	Name     string
+	SaveDays int // Retention days in this target, 0 = the project's object storage retention
+	S3Config
+}
+
+// ReplicaResult records the outcome of copying a backup to a replica target
+type ReplicaResult struct {
+	Name   string
+	Result string // Success or Failed
+	Err    string
+}
+
+// ForProject returns a copy of the replica applying the project's limits and the replica's retention
+func (replica ReplicaTarget) ForProject(backupConf BackupConfig) ReplicaTarget {
+	backupConf.SaveDaysS3 = replica.GetSaveDays(backupConf)
+	replica.S3Config = replica.S3Config.ForProject(backupConf)
+	return replica
+}
+
+// GetSaveDays returns the retention days of the project in this replica
+func (replica ReplicaTarget) GetSaveDays(backupConf BackupConfig) int {
+	if replica.SaveDays > 0 {
+		return replica.SaveDays
+	}
+	return backupConf.SaveDaysS3
+}
+
+// sameProvider reports whether objects can be copied server-side from the primary target
+func (replica ReplicaTarget) sameProvider(primary S3Config) bool {
+	return replica.Endpoint == primary.Endpoint && replica.CredentialSource == primary.CredentialSource &&
+		replica.AccessKey == primary.AccessKey && replica.Profile == primary.Profile && replica.RoleARN == primary.RoleARN
+}
+
+// Replicate copies an object from the primary target to the replica and verifies its checksum.
+// Objects are copied server-side on the same provider and streamed through otherwise.
+func (replica ReplicaTarget) Replicate(primary S3Config, key string) error {
+	primarySession, err := primary.getSession()
+	if err != nil {
+		return err
+	}
+	replicaSession, err := replica.getSession()
+	if err != nil {
+		return err
+	}
+	source := s3.New(primarySession)
+	target := s3.New(replicaSession)
+
+	head, err := source.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(primary.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	checksum := getChecksum(head.Metadata)
+
+	log.Printf("%s is being replicated to %s...\n", key, replica.Name)
+	if replica.sameProvider(primary) {
+		err = replica.copyObject(target, primary.BucketName, key, head)
+	} else {
+		checksum, err = replica.streamObject(source, replicaSession, primary.BucketName, key, checksum)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Verify the copy
+	copied, err := target.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(replica.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	if aws.Int64Value(copied.ContentLength) != aws.Int64Value(head.ContentLength) {
+		return replica.discardCopy(key, fmt.Errorf("%s size in %s is %d bytes, expected %d bytes", key, replica.Name, aws.Int64Value(copied.ContentLength), aws.Int64Value(head.ContentLength)))
+	}
+	if checksum != "" && getChecksum(copied.Metadata) != checksum {
+		return replica.discardCopy(key, fmt.Errorf("%s checksum in %s does not match", key, replica.Name))
+	}
+
+	log.Printf("%s successfully replicated to %s\n", key, replica.Name)
+	return nil
+}
+
+// copyObject copies an object server-side, in parts when it is too large for a single request
+func (replica ReplicaTarget) copyObject(target *s3.S3, sourceBucket string, key string, head *s3.HeadObjectOutput) error {
+	copySource := url.PathEscape(sourceBucket + "/" + key)
+	size := aws.Int64Value(head.ContentLength)
+	if size <= maxCopyObjectSize {
+		input := &s3.CopyObjectInput{
+			Bucket:     aws.String(replica.BucketName),
+			Key:        aws.String(key),
+			CopySource: aws.String(copySource),
+		}
+		replica.applyObjectOptions(input)
+		_, err := target.CopyObject(input)
+		return err
+	}
+
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(replica.BucketName),
+		Key:      aws.String(key),
+		Metadata: head.Metadata,
+	}
+	replica.applyObjectOptions(input)
+	upload, err := target.CreateMultipartUpload(input)
+	if err != nil {
+		return err
+	}
+
+	parts := make([]*s3.CompletedPart, 0)
+	for offset, partNumber := int64(0), int64(1); offset < size; offset, partNumber = offset+copyPartSize, partNumber+1 {
+		end := offset + copyPartSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		output, err := target.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:          aws.String(replica.BucketName),
+			Key:             aws.String(key),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int64(partNumber),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+		})
+		if err != nil {
+			abortMultipartUpload(target, replica.BucketName, key, aws.StringValue(upload.UploadId))
+			return err
+		}
+		parts = append(parts, &s3.CompletedPart{PartNumber: aws.Int64(partNumber), ETag: output.CopyPartResult.ETag})
+	}
+
+	_, err = target.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(replica.BucketName),
+		Key:             aws.String(key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// streamObject downloads an object from the primary target while uploading it to the replica,
+// verifying the streamed content against the expected checksum when the source has one
+func (replica ReplicaTarget) streamObject(source *s3.S3, replicaSession *session.Session, sourceBucket string, key string, expected string) (string, error) {
+	object, err := source.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(sourceBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer object.Body.Close()
+
+	// The checksum is known only after streaming, so the metadata carries the expected one
+	hash := sha256.New()
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(replica.BucketName),
+		Key:    aws.String(key),
+		Body:   io.TeeReader(object.Body, hash),
+	}
+	if expected != "" {
+		input.Metadata = checksumMetadata(expected)
+	}
+	replica.applyObjectOptions(input)
+
+	uploader := s3manager.NewUploader(replicaSession, func(uploader *s3manager.Uploader) {
+		uploader.PartSize = replica.getPartSize(aws.Int64Value(object.ContentLength))
+		uploader.Concurrency = replica.getConcurrency()
+	})
+	if _, err = uploader.Upload(input); err != nil {
+		return "", err
+	}
+
+	if expected != "" && hex.EncodeToString(hash.Sum(nil)) != expected {
+		return "", replica.discardCopy(key, fmt.Errorf("%s checksum changed while streaming to %s", key, replica.Name))
+	}
+	return expected, nil
+}
+
+// discardCopy deletes a copy that failed verification, so the replica never keeps a corrupt backup
+// under the name of a valid one, and returns the verification error
+func (replica ReplicaTarget) discardCopy(key string, verifyErr error) error {
+	if err := replica.DeleteFile(key); err != nil {
+		log.Printf("Failed to delete the corrupt copy of %s from %s, ERR: %s\n", key, replica.Name, err)
+	} else {
+		log.Printf("Deleted the corrupt copy of %s from %s\n", key, replica.Name)
+	}
+	return verifyErr
+}
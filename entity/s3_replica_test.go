@@ -0,0 +1,34 @@
+package entity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestReplicate(t *testing.T) {
+	useTempDir(t)
+	primaryFake, primary := newMemoryS3(t)
+	replicaFake, replicaConf := newMemoryS3(t)
+	replica := ReplicaTarget{Name: "dr", S3Config: replicaConf}
+
+	sum := sha256.Sum256([]byte("dump"))
+	checksum := hex.EncodeToString(sum[:])
+	primaryFake.putObject("backup-x-files/db/good.sql", []byte("dump"), checksum)
+	if err := replica.Replicate(primary, "backup-x-files/db/good.sql"); err != nil {
+		t.Fatal(err)
+	}
+	if copied, _ := replicaFake.getObject("backup-x-files/db/good.sql"); !bytes.Equal(copied, []byte("dump")) {
+		t.Errorf("Object not replicated: %s", copied)
+	}
+
+	// The content no longer matches the checksum it was uploaded with
+	primaryFake.putObject("backup-x-files/db/corrupt.sql", []byte("dump!"), checksum)
+	if err := replica.Replicate(primary, "backup-x-files/db/corrupt.sql"); err == nil {
+		t.Error("A checksum mismatch must fail the replication")
+	}
+	if _, ok := replicaFake.getObject("backup-x-files/db/corrupt.sql"); ok {
+		t.Error("A copy failing verification must be deleted from the replica")
+	}
+}
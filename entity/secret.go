@@ -0,0 +1,131 @@
+package entity
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// SecretEnvPrefix prefixes the environment variables secrets are passed to commands in
+const SecretEnvPrefix = "BACKUP_X_SECRET_"
+
+var secretNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// referencePattern matches the placeholders expanding to a quoted reference: #{PWD}, #{SecretKey} and #{secret:NAME}
+const referencePattern = `#\{(?:PWD|SecretKey|secret:[A-Za-z0-9_]+)(?:\|[a-z]+)?\}`
+
+var referenceRegexp = regexp.MustCompile(`^` + referencePattern)
+
+var quotedReferenceRegexp = regexp.MustCompile(`'(` + referencePattern + `)'|"(` + referencePattern + `)"`)
+
+// Secret is a named credential referenced from commands as #{secret:NAME}.
+// The value is encrypted with Config.EncryptKey and never shown in the web UI
+type Secret struct {
+	Name  string
+	Value string
+}
+
+// CheckSecretName checks that a secret name can be used as part of an environment variable name
+func CheckSecretName(name string) bool {
+	return secretNameRegexp.MatchString(name)
+}
+
+// GetEnvName returns the environment variable the secret is passed in
+func (secret Secret) GetEnvName() string {
+	return SecretEnvPrefix + strings.ToUpper(secret.Name)
+}
+
+// GetEnvReference returns the shell expression expanding to the secret at run time
+func (secret Secret) GetEnvReference() string {
+	return EnvReference(secret.GetEnvName())
+}
+
+// EnvReference returns the shell expression expanding to an environment variable, quoted as one argument.
+// On Windows scripts run with delayed expansion, so the value of !NAME! is not parsed by cmd again
+func EnvReference(envName string) string {
+	if runtime.GOOS == "windows" {
+		return "\"!" + envName + "!\""
+	}
+	return "\"${" + envName + "}\""
+}
+
+// QuotedReference returns the first #{PWD}, #{SecretKey} or #{secret:NAME} of a command that is inside quotes,
+// where its quoted reference would end the quoting instead of keeping the value as one argument
+func QuotedReference(command string) string {
+	quote := byte(0)
+	for i := 0; i < len(command); i++ {
+		if quote != 0 {
+			if match := referenceRegexp.FindString(command[i:]); match != "" {
+				return match
+			}
+		}
+		switch c := command[i]; {
+		case c == '\\' && quote != '\'' && runtime.GOOS != "windows":
+			i++
+		case c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\'' && runtime.GOOS != "windows"):
+			quote = c
+		}
+	}
+	return ""
+}
+
+// unquoteReferences removes quotes written directly around #{PWD}, #{SecretKey} and #{secret:NAME}
+// in the commands, hooks and templates of older versions, whose placeholders were replaced with the plain value.
+// Returns whether a command was changed
+func (conf *Config) unquoteReferences() (changed bool) {
+	commands := make([]*string, 0, len(conf.BackupConfig)*5+len(conf.Templates))
+	for i := range conf.BackupConfig {
+		backupConf := &conf.BackupConfig[i]
+		commands = append(commands, &backupConf.Command, &backupConf.PreHook, &backupConf.PostSuccessHook, &backupConf.PostFailureHook, &backupConf.CleanupHook)
+	}
+	for i := range conf.Templates {
+		commands = append(commands, &conf.Templates[i].Command)
+	}
+	for _, command := range commands {
+		if unquoted := quotedReferenceRegexp.ReplaceAllString(*command, "$1$2"); unquoted != *command {
+			*command = unquoted
+			changed = true
+		}
+	}
+	return changed
+}
+
+// warnQuotedReferences logs the references unquoteReferences left inside quotes, like in '-p#{PWD}',
+// the commands fail to run until they are changed
+func (conf *Config) warnQuotedReferences() {
+	for _, backupConf := range conf.BackupConfig {
+		for _, command := range []string{backupConf.Command, backupConf.PreHook, backupConf.PostSuccessHook, backupConf.PostFailureHook, backupConf.CleanupHook} {
+			if ref := QuotedReference(command); ref != "" {
+				log.Printf("Project %s: %s expands to a quoted value itself, move it out of the quotes, the command fails until then\n", backupConf.ProjectName, ref)
+			}
+		}
+	}
+	for _, tmpl := range conf.Templates {
+		if ref := QuotedReference(tmpl.Command); ref != "" {
+			log.Printf("Template %s: %s expands to a quoted value itself, move it out of the quotes\n", tmpl.Name, ref)
+		}
+	}
+}
+
+// GetSecretEnv returns the references of the secrets used by a command, to be expanded as #{secret:NAME},
+// and the environment variables holding their decrypted values
+func (conf *Config) GetSecretEnv(command string) (refs map[string]string, env []string, err error) {
+	refs = make(map[string]string)
+	for _, secret := range conf.Secrets {
+		token := "#{secret:" + secret.Name
+		if !strings.Contains(command, token+"}") && !strings.Contains(command, token+"|") {
+			continue
+		}
+		value, err := conf.ResolveSecret(secret.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolving secret %s failed: %s", secret.Name, err)
+		}
+		refs["secret:"+secret.Name] = secret.GetEnvReference()
+		env = append(env, secret.GetEnvName()+"="+value)
+	}
+	return refs, env, nil
+}
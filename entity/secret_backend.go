@@ -0,0 +1,197 @@
+package entity
+
+import (
+	"backup-x/util"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefixes of secret references, resolved at run time instead of being stored encrypted
+const (
+	SecretRefVault = "vault:" // vault:secret/data/backup-x#password, the key defaults to "value"
+	SecretRefFile  = "file:"  // file:/run/secrets/db_password
+	SecretRefEnv   = "env:"   // env:DB_PASSWORD
+)
+
+// VaultConfig is the HashiCorp Vault used by vault: references
+type VaultConfig struct {
+	Address   string // Defaults to VAULT_ADDR
+	Namespace string
+	Token     string // Encrypted or a file:/env: reference, defaults to VAULT_TOKEN
+	RoleID    string // AppRole login instead of a token
+	SecretID  string // Encrypted or a file:/env: reference
+}
+
+// vaultToken caches the token of the last AppRole login
+var vaultToken = struct {
+	sync.Mutex
+	login   string
+	token   string
+	expires time.Time
+}{}
+
+// IsSecretRef checks whether a value is a reference to an external secret rather than an encrypted value
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, SecretRefVault) || strings.HasPrefix(value, SecretRefFile) || strings.HasPrefix(value, SecretRefEnv)
+}
+
+// ResolveSecret returns the plain value of a secret stored encrypted or referenced from Vault, a file or the environment
+func (conf *Config) ResolveSecret(value string) (string, error) {
+	if strings.HasPrefix(value, SecretRefVault) {
+		path := strings.TrimPrefix(value, SecretRefVault)
+		key := "value"
+		if i := strings.LastIndex(path, "#"); i >= 0 {
+			path, key = path[:i], path[i+1:]
+		}
+		return conf.readVaultSecret(path, key)
+	}
+	return conf.resolveLocalSecret(value)
+}
+
+// resolveLocalSecret resolves a value that is encrypted or referenced from a file or the environment
+func (conf *Config) resolveLocalSecret(value string) (string, error) {
+	switch {
+	case value == "":
+		return "", nil
+	case strings.HasPrefix(value, SecretRefEnv):
+		name := strings.TrimPrefix(value, SecretRefEnv)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, SecretRefFile):
+		byt, err := ioutil.ReadFile(strings.TrimPrefix(value, SecretRefFile))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(byt), "\r\n"), nil
+	case strings.HasPrefix(value, SecretRefVault):
+		return "", fmt.Errorf("vault references are not supported here")
+	}
+	return util.DecryptByEncryptKey(conf.EncryptKey, value)
+}
+
+// readVaultSecret reads a key of a KV secret, version 1 or 2, from Vault
+func (conf *Config) readVaultSecret(path string, key string) (string, error) {
+	address := conf.Vault.getAddress()
+	if address == "" {
+		return "", fmt.Errorf("vault address is not configured")
+	}
+	token, err := conf.getVaultToken(address)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Data map[string]interface{}
+	}
+	err = conf.Vault.request(http.MethodGet, address+"/v1/"+strings.TrimPrefix(path, "/"), token, nil, &response)
+	if err != nil {
+		return "", err
+	}
+
+	data := response.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested
+	}
+	value, ok := data[key]
+	if !ok || value == nil {
+		return "", fmt.Errorf("vault secret %s has no key %s", path, key)
+	}
+	if str, ok := value.(string); ok {
+		return str, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// getVaultToken returns the configured token or logs in with AppRole
+func (conf *Config) getVaultToken(address string) (string, error) {
+	if conf.Vault.RoleID == "" {
+		if conf.Vault.Token == "" {
+			return os.Getenv("VAULT_TOKEN"), nil
+		}
+		return conf.resolveLocalSecret(conf.Vault.Token)
+	}
+
+	vaultToken.Lock()
+	defer vaultToken.Unlock()
+
+	login := address + "|" + conf.Vault.Namespace + "|" + conf.Vault.RoleID
+	if vaultToken.login == login && time.Now().Before(vaultToken.expires) {
+		return vaultToken.token, nil
+	}
+
+	secretID, err := conf.resolveLocalSecret(conf.Vault.SecretID)
+	if err != nil {
+		return "", err
+	}
+	body, _ := json.Marshal(map[string]string{"role_id": conf.Vault.RoleID, "secret_id": secretID})
+	var response struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		}
+	}
+	err = conf.Vault.request(http.MethodPost, address+"/v1/auth/approle/login", "", body, &response)
+	if err != nil {
+		return "", err
+	}
+	if response.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault AppRole login returned no token")
+	}
+
+	vaultToken.login = login
+	vaultToken.token = response.Auth.ClientToken
+	// Renew by logging in again shortly before the token expires
+	vaultToken.expires = time.Now().Add(time.Duration(response.Auth.LeaseDuration) * time.Second * 9 / 10)
+	return vaultToken.token, nil
+}
+
+// request sends a request to the Vault API and decodes the JSON response
+func (vault VaultConfig) request(method string, url string, token string, body []byte, response interface{}) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if vault.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", vault.Namespace)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	byt, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct{ Errors []string }
+		json.Unmarshal(byt, &vaultErr)
+		return fmt.Errorf("vault request %s failed with status %d: %s", req.URL.Path, resp.StatusCode, strings.Join(vaultErr.Errors, ", "))
+	}
+	return json.Unmarshal(byt, response)
+}
+
+// getAddress returns the configured Vault address or VAULT_ADDR
+func (vault VaultConfig) getAddress() string {
+	address := vault.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	return strings.TrimSuffix(address, "/")
+}
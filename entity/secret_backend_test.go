@@ -0,0 +1,78 @@
+package entity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeVault serves a KV version 2 secret and AppRole logins like a Vault dev server
+func fakeVault(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			var login map[string]string
+			json.NewDecoder(r.Body).Decode(&login)
+			if login["role_id"] != "role" || login["secret_id"] != "approle-secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors":["invalid role or secret ID"]}`))
+				return
+			}
+			w.Write([]byte(`{"auth":{"client_token":"approle-token","lease_duration":3600}}`))
+		case "/v1/secret/data/backup-x":
+			token := r.Header.Get("X-Vault-Token")
+			if token != "root" && token != "approle-token" {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			w.Write([]byte(`{"data":{"data":{"password":"from-vault","value":"default"},"metadata":{"version":1}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+}
+
+// TestResolveSecret resolves references to Vault, files and environment variables
+func TestResolveSecret(t *testing.T) {
+	server := fakeVault(t)
+	defer server.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "db_password")
+	os.WriteFile(secretFile, []byte("from-file\n"), 0600)
+	t.Setenv("BACKUP_X_TEST_SECRET", "from-env")
+	t.Setenv("BACKUP_X_TEST_TOKEN", "root")
+
+	conf := &Config{Vault: VaultConfig{Address: server.URL, Token: "env:BACKUP_X_TEST_TOKEN"}}
+	cases := map[string]string{
+		"vault:secret/data/backup-x#password": "from-vault",
+		"vault:secret/data/backup-x":          "default",
+		"file:" + secretFile:                  "from-file",
+		"env:BACKUP_X_TEST_SECRET":            "from-env",
+	}
+	for ref, want := range cases {
+		if got, err := conf.ResolveSecret(ref); err != nil || got != want {
+			t.Errorf("ResolveSecret(%s) = %s, %v, want %s", ref, got, err, want)
+		}
+	}
+
+	if _, err := conf.ResolveSecret("vault:secret/data/backup-x#missing"); err == nil {
+		t.Error("Missing key must fail")
+	}
+	if _, err := conf.ResolveSecret("env:BACKUP_X_TEST_UNSET"); err == nil {
+		t.Error("Unset environment variable must fail")
+	}
+
+	conf.Vault = VaultConfig{Address: server.URL, RoleID: "role", SecretID: "file:" + secretFile}
+	if _, err := conf.ResolveSecret("vault:secret/data/backup-x#password"); err == nil {
+		t.Error("AppRole login with a wrong secret ID must fail")
+	}
+	os.WriteFile(secretFile, []byte("approle-secret"), 0600)
+	if got, err := conf.ResolveSecret("vault:secret/data/backup-x#password"); err != nil || got != "from-vault" {
+		t.Errorf("AppRole login failed: %s, %v", got, err)
+	}
+}
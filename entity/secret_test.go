@@ -0,0 +1,69 @@
+package entity
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestQuotedReference(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("single quotes are no quotes for cmd")
+	}
+	cases := []struct {
+		command string
+		want    string
+	}{
+		{"mysqldump -p#{PWD} db > #{DATE}.sql", ""},
+		{`curl -u "admin:#{secret:TOKEN}" https://x`, "#{secret:TOKEN}"},
+		{"echo 'it''s #{SecretKey|raw}'", "#{SecretKey|raw}"},
+		{`echo \"#{PWD} "it's" '"' #{secret:TOKEN}`, ""},
+		{`echo "#{projectName}"`, ""},
+	}
+	for _, c := range cases {
+		if got := QuotedReference(c.command); got != c.want {
+			t.Errorf("QuotedReference(%q) = %q, want %q", c.command, got, c.want)
+		}
+	}
+}
+
+func TestUnquoteReferences(t *testing.T) {
+	conf := Config{
+		BackupConfig: []BackupConfig{{
+			Command:         `mysqldump -p'#{PWD}' db > "#{DATE}.sql"`,
+			PreHook:         `curl -H "Authorization: #{secret:TOKEN}" -d "#{secret:TOKEN}"`,
+			PostSuccessHook: `echo '#{SecretKey|raw}'`,
+		}},
+		Templates: []ProjectTemplate{{Command: `pg_dump "#{PWD}"`}},
+	}
+	if !conf.unquoteReferences() {
+		t.Fatal("Quotes not removed")
+	}
+	backupConf := conf.BackupConfig[0]
+	if backupConf.Command != `mysqldump -p#{PWD} db > "#{DATE}.sql"` ||
+		backupConf.PreHook != `curl -H "Authorization: #{secret:TOKEN}" -d #{secret:TOKEN}` ||
+		backupConf.PostSuccessHook != `echo #{SecretKey|raw}` || conf.Templates[0].Command != `pg_dump #{PWD}` {
+		t.Errorf("Quotes not removed correctly: %+v %+v", backupConf, conf.Templates)
+	}
+	if conf.unquoteReferences() {
+		t.Error("Unquoted commands must not change again")
+	}
+}
+
+func TestGetSecretEnv(t *testing.T) {
+	conf := Config{Secrets: []Secret{{Name: "DB", Value: "env:HOME"}, {Name: "DB_ADMIN", Value: "env:HOME"}}}
+	refs, env, err := conf.GetSecretEnv("mysqldump -p#{secret:DB_ADMIN} && echo #{secret:DB|raw}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || len(env) != 2 {
+		t.Errorf("Secrets not found: %v", refs)
+	}
+	if refs, _, _ := conf.GetSecretEnv("mysqldump -p#{secret:DB_ADMIN}"); len(refs) != 1 || refs["secret:DB_ADMIN"] == "" {
+		t.Errorf("A secret must only match its own name: %v", refs)
+	}
+
+	conf.Secrets = append(conf.Secrets, Secret{Name: "db", Value: "env:HOME"})
+	if errs := conf.Validate(); len(errs) != 1 || errs[0].Field != "SecretName" || errs[0].Index != 2 {
+		t.Errorf("Secret names only differing in case must be rejected: %v", errs)
+	}
+}
@@ -58,20 +58,18 @@ func (webhook Webhook) ExecWebhook(result BackupResult) {
 	}
 }
 
-// replaceURL replaces placeholders in the webhook URL with actual values
-func (webhook Webhook) replaceURL(result BackupResult) (newURL string) {
-	newURL = strings.ReplaceAll(webhook.WebhookURL, "#{projectName}", result.ProjectName)
-	newURL = strings.ReplaceAll(newURL, "#{fileName}", result.FileName)
-	newURL = strings.ReplaceAll(newURL, "#{fileSize}", result.FileSize)
-	newURL = strings.ReplaceAll(newURL, "#{result}", result.Result)
-	return newURL
+// replaceURL replaces placeholders in the webhook URL with actual values, URL-escaped by default
+func (webhook Webhook) replaceURL(result BackupResult) (newBody string) {
+	return result.TemplateContext().Expand(webhook.WebhookURL, util.EscapeModeURL)
 }
 
-// replaceBody replaces placeholders in the webhook request body with actual values
+// replaceBody replaces placeholders in the webhook request body with actual values,
+// JSON-escaped by default for a JSON body and URL-escaped for a form body
 func (webhook Webhook) replaceBody(result BackupResult) (newBody string) {
-	newBody = strings.ReplaceAll(webhook.WebhookRequestBody, "#{projectName}", result.ProjectName)
-	newBody = strings.ReplaceAll(newBody, "#{fileName}", result.FileName)
-	newBody = strings.ReplaceAll(newBody, "#{fileSize}", result.FileSize)
-	newBody = strings.ReplaceAll(newBody, "#{result}", result.Result)
-	return newBody
+	mode := util.EscapeModeURL
+	body := strings.TrimSpace(webhook.WebhookRequestBody)
+	if strings.HasPrefix(body, "{") || strings.HasPrefix(body, "[") {
+		mode = util.EscapeModeJSON
+	}
+	return result.TemplateContext().Expand(webhook.WebhookRequestBody, mode)
 }
@@ -2,18 +2,24 @@ package main
 
 import (
 	"backup-x/client"
+	"backup-x/entity"
 	"backup-x/util"
 	"backup-x/web"
+	"bufio"
 	"embed"
 	"flag"
+	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/kardianos/service"
+	"golang.org/x/term"
 )
 
 // 监听地址
@@ -28,6 +34,29 @@ var backupDirDefault, _ = os.Getwd()
 // 配置文件路径
 var backupDir = flag.String("d", backupDirDefault, "自定义备份目录地址")
 
+// 配置文件路径
+var configFile = flag.String("config", "", "自定义配置文件路径, 相对路径基于备份目录, 默认为 backup-x-files/.backup_x_config.yaml")
+
+// 只读的声明式配置文件
+var declarativeFile = flag.String("c", "", "从YAML/TOML配置文件读取配置, 支持${ENV}环境变量, 配置只读, 页面不可修改")
+
+// 主密钥文件
+var keyFile = flag.String("keyFile", "", "从独立的密钥文件读取主密钥(权限须为600), 文件不存在时自动生成")
+
+// 启动时输入口令
+var passphrase = flag.Bool("passphrase", false, "启动时输入口令, 使用argon2id派生主密钥")
+
+// 导出、导入配置
+var exportFile = flag.String("export", "", "导出配置到文件后退出, 密码使用口令重新加密")
+var importFile = flag.String("import", "", "从文件导入配置后退出")
+var importMode = flag.String("importMode", entity.ImportMerge, "导入方式, 支持merge, replace")
+
+// 导出、导入口令的环境变量
+const bundlePassphraseEnv = "BACKUP_X_BUNDLE_PASSPHRASE"
+
+// 轮换加密密钥
+var rotateKey = flag.Bool("rotateKey", false, "生成新的加密密钥, 重新加密配置中的所有密码后退出")
+
 //go:embed static
 var staticEmbededFiles embed.FS
 
@@ -45,6 +74,34 @@ func main() {
 
 	os.Setenv(web.VersionEnv, version)
 
+	if *serviceType == "install" && *passphrase {
+		log.Fatalln("服务无法在启动时输入口令, 请使用 -keyFile 或环境变量提供主密钥后再安装服务")
+	}
+
+	if *configFile != "" {
+		entity.SetConfigFilePath(*configFile)
+	}
+
+	loadMasterKey()
+	loadDeclarativeConfig()
+
+	if *exportFile != "" || *importFile != "" {
+		os.Chdir(*backupDir)
+		if err := exportOrImport(); err != nil {
+			log.Fatalf("导出/导入配置失败, %s", err)
+		}
+		return
+	}
+
+	if *rotateKey {
+		os.Chdir(*backupDir)
+		if err := entity.RotateEncryptKey(); err != nil {
+			log.Fatalf("轮换加密密钥失败, %s", err)
+		}
+		log.Println("轮换加密密钥成功, 如 backup-x 正在运行请重启")
+		return
+	}
+
 	switch *serviceType {
 	case "install":
 		installService()
@@ -73,6 +130,101 @@ func main() {
 	}
 }
 
+// loadMasterKey 从环境变量、密钥文件或口令加载主密钥, 配置文件中只保存密钥标识
+func loadMasterKey() {
+	err := entity.LoadMasterKeyFromEnv()
+	if err == nil && *keyFile != "" {
+		err = entity.LoadMasterKeyFile(*keyFile)
+	}
+	if err == nil && *passphrase {
+		var input string
+		input, err = readPassphrase("请输入口令: ")
+		if err == nil {
+			err = entity.SetMasterPassphrase(input)
+		}
+	}
+	if err != nil {
+		log.Fatalf("加载主密钥失败, %s", err)
+	}
+}
+
+// loadDeclarativeConfig 加载声明式配置文件, 配置校验失败时退出
+func loadDeclarativeConfig() {
+	if *declarativeFile == "" {
+		return
+	}
+	path, err := filepath.Abs(*declarativeFile)
+	if err == nil {
+		err = entity.LoadDeclarativeConfig(path)
+	}
+	if errs, ok := err.(entity.ValidationErrors); ok {
+		for _, fieldErr := range errs {
+			log.Println(fieldErr.Message)
+		}
+		log.Fatalf("配置文件 %s 校验失败, 共 %d 个错误", path, len(errs))
+	}
+	if err != nil {
+		log.Fatalf("加载配置文件失败, %s", err)
+	}
+	*declarativeFile = path
+	log.Printf("已从 %s 加载配置, 配置只读\n", path)
+}
+
+// exportOrImport 导出或导入配置, 口令从环境变量 BACKUP_X_BUNDLE_PASSPHRASE 读取或在终端输入
+func exportOrImport() error {
+	bundlePassphrase := os.Getenv(bundlePassphraseEnv)
+	if bundlePassphrase == "" {
+		var err error
+		bundlePassphrase, err = readPassphrase("请输入导出/导入口令: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	if *exportFile != "" {
+		conf, err := entity.GetConfigCache()
+		if err != nil {
+			return err
+		}
+		bundle, err := conf.Export(bundlePassphrase)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*exportFile, bundle, 0600); err != nil {
+			return err
+		}
+		log.Printf("配置已导出到 %s\n", *exportFile)
+		return nil
+	}
+
+	bundle, err := os.ReadFile(*importFile)
+	if err != nil {
+		return err
+	}
+	if _, err := entity.ImportBundle(bundle, bundlePassphrase, *importMode); err != nil {
+		return err
+	}
+	log.Printf("已从 %s 导入配置, 如 backup-x 正在运行请重启\n", *importFile)
+	return nil
+}
+
+// readPassphrase 在终端输入口令, 不回显
+func readPassphrase(prompt string) (string, error) {
+	fmt.Print(prompt)
+	var input []byte
+	var err error
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		input, err = term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+	} else {
+		input, err = bufio.NewReader(os.Stdin).ReadBytes('\n')
+	}
+	if err != nil && len(input) == 0 {
+		return "", err
+	}
+	return strings.TrimRight(string(input), "\r\n"), nil
+}
+
 func staticFsFunc(writer http.ResponseWriter, request *http.Request) {
 	http.FileServer(http.FS(staticEmbededFiles)).ServeHTTP(writer, request)
 }
@@ -82,6 +234,10 @@ func faviconFsFunc(writer http.ResponseWriter, request *http.Request) {
 }
 
 func run(firstDelay time.Duration) {
+	// 备份命令和钩子不继承仅用于轮换密钥和导出/导入的口令
+	os.Unsetenv(entity.NewEncryptKeyEnv)
+	os.Unsetenv(bundlePassphraseEnv)
+
 	// 启动静态文件服务
 	http.HandleFunc("/static/", web.BasicAuth(staticFsFunc))
 	http.HandleFunc("/favicon.ico", web.BasicAuth(faviconFsFunc))
@@ -91,10 +247,31 @@ func run(firstDelay time.Duration) {
 	http.HandleFunc("/logs", web.BasicAuth(web.Logs))
 	http.HandleFunc("/clearLog", web.BasicAuth(web.ClearLog))
 	http.HandleFunc("/webhookTest", web.BasicAuth(web.WebhookTest))
+	http.HandleFunc("/artifacts", web.BasicAuth(web.Artifacts))
+	http.HandleFunc("/artifacts/download", web.BasicAuth(web.ArtifactDownload))
+	http.HandleFunc("/artifacts/delete", web.BasicAuth(web.ArtifactDelete))
+	http.HandleFunc("/artifacts/hold", web.BasicAuth(web.ArtifactHold))
+	http.HandleFunc("/api/holds", web.BasicAuth(web.Holds))
+	http.HandleFunc("/api/projects", web.BasicAuth(web.Projects))
+	http.HandleFunc("/api/projects/bulk", web.BasicAuth(web.ProjectsBulk))
+	http.HandleFunc("/api/templates", web.BasicAuth(web.Templates))
+	http.HandleFunc("/api/templates/render", web.BasicAuth(web.TemplateRender))
+	http.HandleFunc("/config/export", web.BasicAuth(web.ExportConfig))
+	http.HandleFunc("/config/import", web.BasicAuth(web.ImportConfig))
+	http.HandleFunc("/config/history", web.BasicAuth(web.History))
+	http.HandleFunc("/config/rollback", web.BasicAuth(web.Rollback))
 
 	// 改变工作目录
 	os.Chdir(*backupDir)
 
+	// 配置无法读取或解密时退出, 不以缺少密码的配置运行
+	if _, err := entity.GetConfigCache(); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("加载配置失败, %s", err)
+	}
+
+	// 清理中断运行遗留的脚本
+	client.SweepShellFiles()
+
 	// 运行
 	go client.DeleteOldBackup()
 	go client.RunLoop(firstDelay)
@@ -138,11 +315,23 @@ func getService() service.Service {
 			"After=network-online.target")
 	}
 
+	arguments := []string{"-l", *listen, "-d", *backupDir}
+	if *configFile != "" {
+		arguments = append(arguments, "-config", *configFile)
+	}
+	// 服务的工作目录不同, 文件使用绝对路径
+	if *declarativeFile != "" {
+		arguments = append(arguments, "-c", absPath(*declarativeFile))
+	}
+	if *keyFile != "" {
+		arguments = append(arguments, "-keyFile", absPath(*keyFile))
+	}
+
 	svcConfig := &service.Config{
 		Name:         "backup-x",
 		DisplayName:  "backup-x",
 		Description:  "带Web界面的数据库/文件备份增强工具",
-		Arguments:    []string{"-l", *listen, "-d", *backupDir},
+		Arguments:    arguments,
 		Dependencies: depends,
 		Option:       options,
 	}
@@ -155,6 +344,15 @@ func getService() service.Service {
 	return s
 }
 
+// absPath 返回相对于当前目录的绝对路径
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return abs
+}
+
 // 卸载服务
 func uninstallService() {
 	s := getService()
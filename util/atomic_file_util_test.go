@@ -0,0 +1,37 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+
+	if err := WriteFileAtomic(path, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(path, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	byt, _ := ioutil.ReadFile(path)
+	if string(byt) != "second" {
+		t.Error("WriteFileAtomic did not replace the file:", string(byt))
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Error("WriteFileAtomic permission not correct:", info.Mode())
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Error("WriteFileAtomic left temporary files behind")
+	}
+
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "config.yaml"), []byte("x"), 0600); err == nil {
+		t.Error("WriteFileAtomic should fail for a missing directory")
+	}
+}
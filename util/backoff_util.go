@@ -0,0 +1,26 @@
+package util
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// BackoffDelay returns the wait time before the given retry (1-based).
+// The delay grows by factor after each retry and is randomized by +/- jitter.
+func BackoffDelay(initial time.Duration, factor float64, jitter float64, retry int) time.Duration {
+	if initial <= 0 || retry <= 0 {
+		return 0
+	}
+	if factor < 1 {
+		factor = 1
+	}
+	delay := float64(initial) * math.Pow(factor, float64(retry-1))
+	if jitter > 0 {
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay = delay * (1 + jitter*(2*rand.Float64()-1))
+	}
+	return time.Duration(delay)
+}
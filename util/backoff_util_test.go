@@ -0,0 +1,24 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	if BackoffDelay(time.Second, 2, 0, 1) != time.Second {
+		t.Error("BackoffDelay first retry not correct")
+	}
+	if BackoffDelay(time.Second, 2, 0, 3) != 4*time.Second {
+		t.Error("BackoffDelay third retry not correct")
+	}
+	if BackoffDelay(0, 2, 0, 3) != 0 {
+		t.Error("BackoffDelay without initial delay not correct")
+	}
+	for i := 0; i < 100; i++ {
+		delay := BackoffDelay(10*time.Second, 1, 0.5, 1)
+		if delay < 5*time.Second || delay > 15*time.Second {
+			t.Error("BackoffDelay jitter out of range")
+		}
+	}
+}
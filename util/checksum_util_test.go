@@ -0,0 +1,16 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSha256(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "2021-11-11-01-01.sql")
+	os.WriteFile(fileName, []byte("abc"), 0600)
+	checksum, err := FileSha256(fileName)
+	if err != nil || checksum != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Error("FileSha256 not correct")
+	}
+}
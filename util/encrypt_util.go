@@ -1,6 +1,16 @@
 package util
 
-import "errors"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+// cipherTextV2 prefixes values encrypted with a random nonce per value.
+// Values without a prefix were encrypted with the fixed nonce of the EncryptKey
+const cipherTextV2 = "v2:"
 
 // GenerateEncryptKey Generate a random EncryptKey
 func GenerateEncryptKey() (encryptKey string, err error) {
@@ -12,19 +22,28 @@ func GenerateEncryptKey() (encryptKey string, err error) {
 	return key + nonce, nil
 }
 
-// EncryptByEncryptKey
+// EncryptByEncryptKey encrypts with the key of the EncryptKey and a random nonce, stored in front of the cipher text
 func EncryptByEncryptKey(encryptKey string, orgStr string) (ecryptStr string, err error) {
 	if len(encryptKey) != 88 {
 		return "", errors.New("EncryptKey not corret")
 	}
-	key, nonce, err := ValidateKeyAndNonce(encryptKey[0:64], encryptKey[64:88])
+	key, _, err := ValidateKeyAndNonce(encryptKey[0:64], encryptKey[64:88])
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	cipherText, err := Encrypt(key, nonce, orgStr)
 	if err != nil {
 		return "", err
 	}
-	return Encrypt(key, nonce, orgStr)
+	return cipherTextV2 + hex.EncodeToString(nonce) + cipherText, nil
 }
 
-// DecryptByEncryptKey 
+// DecryptByEncryptKey decrypts values of both the current and the legacy format
 func DecryptByEncryptKey(encryptKey string, encryptStr string) (decryptStr string, err error) {
 	if len(encryptKey) != 88 {
 		return "", errors.New("EncryptKey not corret")
@@ -33,5 +52,22 @@ func DecryptByEncryptKey(encryptKey string, encryptStr string) (decryptStr strin
 	if err != nil {
 		return "", err
 	}
+
+	if strings.HasPrefix(encryptStr, cipherTextV2) {
+		encryptStr = strings.TrimPrefix(encryptStr, cipherTextV2)
+		if len(encryptStr) < 24 {
+			return "", errors.New("cipher text too short")
+		}
+		nonce, err = hex.DecodeString(encryptStr[0:24])
+		if err != nil {
+			return "", err
+		}
+		encryptStr = encryptStr[24:]
+	}
 	return Decrypt(key, nonce, encryptStr)
 }
+
+// IsLegacyCipherText checks whether a value was encrypted with the fixed nonce of the EncryptKey
+func IsLegacyCipherText(encryptStr string) bool {
+	return encryptStr != "" && !strings.HasPrefix(encryptStr, cipherTextV2)
+}
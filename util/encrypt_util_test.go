@@ -0,0 +1,36 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncryptByEncryptKey(t *testing.T) {
+	encryptKey, _ := GenerateEncryptKey()
+
+	first, err := EncryptByEncryptKey(encryptKey, "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := EncryptByEncryptKey(encryptKey, "abc123")
+	if !strings.HasPrefix(first, "v2:") || first == second || IsLegacyCipherText(first) {
+		t.Errorf("Values must be versioned and use a new nonce each time: %s, %s", first, second)
+	}
+	for _, cipherText := range []string{first, second} {
+		if plainText, err := DecryptByEncryptKey(encryptKey, cipherText); err != nil || plainText != "abc123" {
+			t.Errorf("DecryptByEncryptKey = %s, %v", plainText, err)
+		}
+	}
+
+	// Values encrypted with the fixed nonce of the EncryptKey
+	key, nonce, _ := ValidateKeyAndNonce(encryptKey[0:64], encryptKey[64:88])
+	legacy, _ := Encrypt(key, nonce, "abc123")
+	if plainText, err := DecryptByEncryptKey(encryptKey, legacy); err != nil || plainText != "abc123" || !IsLegacyCipherText(legacy) {
+		t.Errorf("Legacy value not decrypted: %s, %v", plainText, err)
+	}
+
+	otherKey, _ := GenerateEncryptKey()
+	if _, err := DecryptByEncryptKey(otherKey, first); err == nil {
+		t.Error("Decryption with another key must fail")
+	}
+}
@@ -0,0 +1,58 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ExpandEnv replaces ${NAME} with the environment variable NAME, and ${NAME:-default} with default
+// when NAME is not set or empty. $${ is kept as ${, e.g. for variables of a shell command.
+// A variable that is not set and has no default is an error
+func ExpandEnv(text string) (string, error) {
+	var builder strings.Builder
+	for {
+		start := strings.Index(text, "${")
+		if start < 0 {
+			break
+		}
+		if start > 0 && text[start-1] == '$' {
+			builder.WriteString(text[:start-1] + "${")
+			text = text[start+2:]
+			continue
+		}
+		end := strings.Index(text[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unclosed ${ in %q", text[start:])
+		}
+		end += start
+
+		name, defaultValue, hasDefault := strings.Cut(text[start+2:end], ":-")
+		if !isEnvName(name) {
+			return "", fmt.Errorf("invalid environment variable name %q", name)
+		}
+		value := os.Getenv(name)
+		if value == "" && hasDefault {
+			value = defaultValue
+		} else if _, ok := os.LookupEnv(name); !ok {
+			return "", fmt.Errorf("environment variable %s is not set, write $${%s} to keep it", name, name)
+		}
+		builder.WriteString(text[:start] + value)
+		text = text[end+1:]
+	}
+	builder.WriteString(text)
+	return builder.String(), nil
+}
+
+// isEnvName checks that name only has letters, digits and underscores and does not start with a digit
+func isEnvName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("BACKUP_X_ENV_TEST", "db.local")
+	t.Setenv("BACKUP_X_ENV_EMPTY", "")
+	t.Setenv("BACKUP_X_ENV_MISSING", "")
+	os.Unsetenv("BACKUP_X_ENV_MISSING")
+
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"mysqldump -h ${BACKUP_X_ENV_TEST}", "mysqldump -h db.local"},
+		{"${BACKUP_X_ENV_MISSING:-3306}/${BACKUP_X_ENV_EMPTY:-x}", "3306/x"},
+		{"[${BACKUP_X_ENV_EMPTY}]", "[]"},
+		{"tar -C $${HOME} . ${BACKUP_X_ENV_TEST}", "tar -C ${HOME} . db.local"},
+		{"no variables $HOME", "no variables $HOME"},
+	}
+	for _, c := range cases {
+		if got, err := ExpandEnv(c.text); err != nil || got != c.want {
+			t.Errorf("ExpandEnv(%q) = %q, %v, want %q", c.text, got, err, c.want)
+		}
+	}
+
+	for _, text := range []string{"${BACKUP_X_ENV_MISSING}", "${BACKUP_X_ENV_TEST", "${1NAME}", "${}"} {
+		if _, err := ExpandEnv(text); err == nil {
+			t.Errorf("ExpandEnv(%q) should fail", text)
+		}
+	}
+}
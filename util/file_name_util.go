@@ -10,24 +10,43 @@ import (
 const FileNameFormatStr = "2006-01-02-15-04"
 const fileNameRegStr = `([\d]{4})-([\d]{2})-([\d]{2})-([\d]{2})-([\d]{2})`
 
+// DatedFile is a file name with its last modification time
+type DatedFile struct {
+	Name    string
+	ModTime time.Time
+}
+
 // FileNameBeforeDays 
 func FileNameBeforeDays(days int, fileNames []string, projectName string) []string {
+	files := make([]DatedFile, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		files = append(files, DatedFile{Name: fileName})
+	}
+	return FilesBeforeDays(days, files, projectName)
+}
+
+// FilesBeforeDays returns the files older than days, dated by the date in the file name,
+// or by the modification time when the name carries no date
+func FilesBeforeDays(days int, files []DatedFile, projectName string) []string {
 	oldFiles := make([]string, 0)
 	// 2006-01-02-15-04
 	fileRegxp := regexp.MustCompile(fileNameRegStr)
 	subDuration, _ := time.ParseDuration("-" + strconv.Itoa(days*24) + "h")
 	before := time.Now().Add(subDuration)
-	for i := 0; i < len(fileNames); i++ {
-		dateString := fileRegxp.FindString(fileNames[i])
-		if dateString != "" {
-			if fileTime, err := time.Parse(FileNameFormatStr, dateString); err == nil && fileTime.Before(before) {
-				oldFiles = append(oldFiles, fileNames[i])
+	for i := 0; i < len(files); i++ {
+		fileTime := files[i].ModTime
+		if dateString := fileRegxp.FindString(files[i].Name); dateString != "" {
+			if t, err := time.Parse(FileNameFormatStr, dateString); err == nil {
+				fileTime = t
 			}
 		}
+		if !fileTime.IsZero() && fileTime.Before(before) {
+			oldFiles = append(oldFiles, files[i].Name)
+		}
 
 	}
 	
-	if len(oldFiles) > 0 && len(oldFiles)-len(fileNames) >= 0 {
+	if len(oldFiles) > 0 && len(oldFiles)-len(files) >= 0 {
 		log.Printf("Project %s expired files include all files, no deletion will be performed!\n", projectName)
 		return []string{}
 	}
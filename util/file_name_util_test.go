@@ -37,3 +37,17 @@ func TestFileNameUtilAll(t *testing.T) {
 		t.Error("TestFileNameUtilAll Test failed!")
 	}
 }
+
+func TestFilesBeforeDays(t *testing.T) {
+	const days = 10
+	files := []DatedFile{
+		{Name: "a2020-10-10-11-12b.sql", ModTime: time.Now()},
+		{Name: "dump.sql", ModTime: time.Now().AddDate(0, 0, -days-1)},
+		{Name: "latest.sql", ModTime: time.Now()},
+		{Name: "unknown.sql"},
+	}
+	deleteFiles := FilesBeforeDays(days, files, "test")
+	if len(deleteFiles) != 2 || deleteFiles[0] != "a2020-10-10-11-12b.sql" || deleteFiles[1] != "dump.sql" {
+		t.Error("TestFilesBeforeDays Test failed!")
+	}
+}
@@ -0,0 +1,9 @@
+package util
+
+import "testing"
+
+func TestFormatFileSize(t *testing.T) {
+	if FormatFileSize(999) != "999 B" || FormatFileSize(1500) != "1.5 KB" || FormatFileSize(2000*1000*1000) != "2.0 GB" {
+		t.Error("FormatFileSize not correct")
+	}
+}
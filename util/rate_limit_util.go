@@ -0,0 +1,68 @@
+package util
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// maxRateLimitChunk is the largest read passed through a rate limited reader at once
+const maxRateLimitChunk = 32 * 1024
+
+// RateLimiter is a token bucket limiting the bytes transferred per second.
+// A nil RateLimiter or one with a non-positive rate does not limit anything.
+type RateLimiter struct {
+	lock           sync.Mutex
+	bytesPerSecond int64
+	tokens         float64
+	last           time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing bytesPerSecond bytes per second
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{bytesPerSecond: bytesPerSecond, last: time.Now()}
+}
+
+// Wait blocks until n bytes may be transferred
+func (limiter *RateLimiter) Wait(n int) {
+	if limiter == nil || limiter.bytesPerSecond <= 0 || n <= 0 {
+		return
+	}
+
+	limiter.lock.Lock()
+	now := time.Now()
+	rate := float64(limiter.bytesPerSecond)
+	limiter.tokens += now.Sub(limiter.last).Seconds() * rate
+	if limiter.tokens > rate {
+		// Allow a burst of at most one second
+		limiter.tokens = rate
+	}
+	limiter.last = now
+	limiter.tokens -= float64(n)
+	var wait time.Duration
+	if limiter.tokens < 0 {
+		wait = time.Duration(-limiter.tokens / rate * float64(time.Second))
+	}
+	limiter.lock.Unlock()
+
+	time.Sleep(wait)
+}
+
+// Reader wraps reader so that reading from it is limited by the limiter
+func (limiter *RateLimiter) Reader(reader io.Reader) io.Reader {
+	return &rateLimitedReader{reader: reader, limiter: limiter}
+}
+
+type rateLimitedReader struct {
+	reader  io.Reader
+	limiter *RateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (n int, err error) {
+	if len(p) > maxRateLimitChunk {
+		p = p[:maxRateLimitChunk]
+	}
+	n, err = r.reader.Read(p)
+	r.limiter.Wait(n)
+	return
+}
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(100 * 1024)
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, limiter.Reader(bytes.NewReader(make([]byte, 30*1024))))
+	if err != nil || n != 30*1024 {
+		t.Error("RateLimiter reader not correct")
+	}
+	if time.Since(start) < 250*time.Millisecond {
+		t.Error("RateLimiter does not limit the rate")
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	var limiter *RateLimiter
+	start := time.Now()
+	limiter.Wait(100 * 1024 * 1024)
+	NewRateLimiter(0).Wait(100 * 1024 * 1024)
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("Unlimited RateLimiter should not wait")
+	}
+}
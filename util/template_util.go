@@ -0,0 +1,152 @@
+package util
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Escape modes of template values, selected with #{name|mode}
+const (
+	EscapeModeRaw   = "raw"
+	EscapeModeShell = "shell"
+	EscapeModeURL   = "url"
+	EscapeModeJSON  = "json"
+)
+
+// timeLayouts are the named layouts of #{time:layout}, other layouts are Go time layouts
+var timeLayouts = map[string]string{
+	"date":     "2006-01-02",
+	"datetime": "2006-01-02 15:04:05",
+	"rfc3339":  time.RFC3339,
+	"file":     FileNameFormatStr,
+}
+
+// TemplateContext holds the values available to a template
+type TemplateContext struct {
+	Values map[string]string
+	Refs   map[string]string // Values inserted as they are, whatever the escape mode
+	Time   time.Time
+}
+
+// Expand replaces the placeholders of text:
+//
+//	#{name}                   a value of the context
+//	#{env:NAME}               an environment variable
+//	#{time}                   the time of the context in RFC 3339
+//	#{time:layout}            the time in a named or Go layout, or "unix"
+//	#{time@Zone:layout}       the time in a time zone, e.g. #{time@UTC:date}
+//
+// Each placeholder may end with |raw, |shell, |url or |json to select how the value is escaped,
+// otherwise defaultMode is used. Unknown placeholders are left unchanged.
+func (ctx TemplateContext) Expand(text string, defaultMode string) string {
+	var builder strings.Builder
+	for {
+		start := strings.Index(text, "#{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(text[start:], "}")
+		if end < 0 {
+			break
+		}
+		end += start
+
+		builder.WriteString(text[:start])
+		placeholder := text[start+2 : end]
+		name, mode := placeholder, defaultMode
+		if i := strings.LastIndex(placeholder, "|"); i >= 0 && isEscapeMode(placeholder[i+1:]) {
+			name, mode = placeholder[:i], placeholder[i+1:]
+		}
+		if ref, ok := ctx.Refs[name]; ok {
+			builder.WriteString(ref)
+		} else if value, ok := ctx.lookup(name); ok {
+			builder.WriteString(EscapeValue(value, mode))
+		} else {
+			builder.WriteString(text[start : end+1])
+		}
+		text = text[end+1:]
+	}
+	builder.WriteString(text)
+	return builder.String()
+}
+
+// lookup returns the value of a placeholder name
+func (ctx TemplateContext) lookup(name string) (string, bool) {
+	if strings.HasPrefix(name, "env:") {
+		return os.LookupEnv(strings.TrimPrefix(name, "env:"))
+	}
+	if name == "time" || strings.HasPrefix(name, "time:") || strings.HasPrefix(name, "time@") {
+		return ctx.formatTime(strings.TrimPrefix(name, "time"))
+	}
+	value, ok := ctx.Values[name]
+	return value, ok
+}
+
+// formatTime formats the time of the context for "@Zone:layout", ":layout", "@Zone" or ""
+func (ctx TemplateContext) formatTime(spec string) (string, bool) {
+	t := ctx.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	layout := time.RFC3339
+	if strings.HasPrefix(spec, "@") {
+		zone := strings.TrimPrefix(spec, "@")
+		if i := strings.Index(zone, ":"); i >= 0 {
+			zone, spec = zone[:i], zone[i:]
+		} else {
+			spec = ""
+		}
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return "", false
+		}
+		t = t.In(location)
+	}
+	if strings.HasPrefix(spec, ":") {
+		layout = strings.TrimPrefix(spec, ":")
+		if layout == "unix" {
+			return strconv.FormatInt(t.Unix(), 10), true
+		}
+		if named, ok := timeLayouts[layout]; ok {
+			layout = named
+		}
+	}
+	return t.Format(layout), true
+}
+
+// EscapeValue escapes a value for the given mode
+func EscapeValue(value string, mode string) string {
+	switch mode {
+	case EscapeModeShell:
+		return QuoteShell(value)
+	case EscapeModeURL:
+		return url.QueryEscape(value)
+	case EscapeModeJSON:
+		var builder strings.Builder
+		encoder := json.NewEncoder(&builder)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(value)
+		quoted := strings.TrimSuffix(builder.String(), "\n")
+		return quoted[1 : len(quoted)-1]
+	}
+	return value
+}
+
+// QuoteShell quotes a value as a single argument of bash, or of cmd on Windows
+func QuoteShell(value string) string {
+	if runtime.GOOS == "windows" {
+		return "\"" + strings.ReplaceAll(value, "\"", "\"\"") + "\""
+	}
+	return "'" + strings.ReplaceAll(value, "'", "'\\''") + "'"
+}
+
+// isEscapeMode checks whether mode is a known escape mode
+func isEscapeMode(mode string) bool {
+	return mode == EscapeModeRaw || mode == EscapeModeShell || mode == EscapeModeURL || mode == EscapeModeJSON
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTemplateExpand(t *testing.T) {
+	t.Setenv("BACKUP_X_TEMPLATE_TEST", "from env")
+	ctx := TemplateContext{
+		Values: map[string]string{"projectName": "db", "error": `it's "broken" & done`},
+		Time:   time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	cases := []struct {
+		text string
+		mode string
+		want string
+	}{
+		{"#{projectName}-#{time:date}.sql", EscapeModeRaw, "db-2022-01-02.sql"},
+		{"#{time:file} #{time:unix} #{time@Asia/Shanghai:15:04}", EscapeModeRaw, "2022-01-02-03-04 1641092645 11:04"},
+		{"#{time}", EscapeModeRaw, "2022-01-02T03:04:05Z"},
+		{"#{env:BACKUP_X_TEMPLATE_TEST}", EscapeModeRaw, "from env"},
+		{"#{unknown} #{time@Nowhere/Zone}", EscapeModeRaw, "#{unknown} #{time@Nowhere/Zone}"},
+		{"?msg=#{error}", EscapeModeURL, "?msg=it%27s+%22broken%22+%26+done"},
+		{`{"msg": "#{error}"}`, EscapeModeJSON, `{"msg": "it's \"broken\" & done"}`},
+		{`{"msg": "#{error|raw}"}`, EscapeModeJSON, `{"msg": "it's "broken" & done"}`},
+		{"#{projectName|json} #{unclosed", EscapeModeRaw, "db #{unclosed"},
+	}
+	for _, c := range cases {
+		if got := ctx.Expand(c.text, c.mode); got != c.want {
+			t.Errorf("Expand(%q, %s) = %q, want %q", c.text, c.mode, got, c.want)
+		}
+	}
+
+	ctx.Refs = map[string]string{"secret:DB": `"${DB}"`}
+	if got := ctx.Expand("#{secret:DB|shell} #{secret:DB}", EscapeModeJSON); got != `"${DB}" "${DB}"` {
+		t.Errorf("Refs must not be escaped: %s", got)
+	}
+
+	if runtime.GOOS != "windows" {
+		if got := ctx.Expand("echo #{error|shell}", EscapeModeRaw); got != `echo 'it'\''s "broken" & done'` {
+			t.Errorf("Shell escaping not correct: %s", got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// TimeWindow is a daily time range in minutes since midnight, End may be before Start to span midnight
+type TimeWindow struct {
+	Start int
+	End   int
+}
+
+// ParseTimeWindows parses comma separated windows such as "22:00-06:00,12:00-13:30"
+func ParseTimeWindows(windows string) ([]TimeWindow, error) {
+	result := make([]TimeWindow, 0)
+	for _, window := range strings.Split(windows, ",") {
+		window = strings.TrimSpace(window)
+		if window == "" {
+			continue
+		}
+		parts := strings.Split(window, "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid time window %s, expected HH:MM-HH:MM", window)
+		}
+		start, err := parseClock(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time window %s, %s", window, err)
+		}
+		end, err := parseClock(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time window %s, %s", window, err)
+		}
+		result = append(result, TimeWindow{Start: start, End: end})
+	}
+	return result, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether the minute of the day falls into the window
+func (window TimeWindow) contains(minute int) bool {
+	if window.Start == window.End {
+		return true
+	}
+	if window.Start < window.End {
+		return minute >= window.Start && minute < window.End
+	}
+	return minute >= window.Start || minute < window.End
+}
+
+// DelayUntilTimeWindow returns how long to wait from now until one of the windows opens,
+// 0 if now is inside a window or no window is configured
+func DelayUntilTimeWindow(windows []TimeWindow, now time.Time) time.Duration {
+	if len(windows) == 0 {
+		return 0
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	wait := minutesPerDay
+	for _, window := range windows {
+		if window.contains(minute) {
+			return 0
+		}
+		if w := (window.Start - minute + minutesPerDay) % minutesPerDay; w < wait {
+			wait = w
+		}
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return midnight.Add(time.Duration(minute+wait) * time.Minute).Sub(now)
+}
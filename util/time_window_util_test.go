@@ -0,0 +1,34 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeWindows(t *testing.T) {
+	windows, err := ParseTimeWindows("22:00-06:00, 12:00-13:30")
+	if err != nil || len(windows) != 2 || windows[0].Start != 22*60 || windows[1].End != 13*60+30 {
+		t.Error("ParseTimeWindows not correct")
+	}
+	if _, err := ParseTimeWindows("22-06"); err == nil {
+		t.Error("ParseTimeWindows should reject invalid windows")
+	}
+	if windows, err := ParseTimeWindows(""); err != nil || len(windows) != 0 {
+		t.Error("ParseTimeWindows should accept empty windows")
+	}
+}
+
+func TestDelayUntilTimeWindow(t *testing.T) {
+	windows, _ := ParseTimeWindows("22:00-06:00")
+	inside := time.Date(2021, 11, 11, 23, 30, 0, 0, time.Local)
+	if DelayUntilTimeWindow(windows, inside) != 0 {
+		t.Error("DelayUntilTimeWindow inside window not correct")
+	}
+	outside := time.Date(2021, 11, 11, 20, 30, 0, 0, time.Local)
+	if DelayUntilTimeWindow(windows, outside) != 90*time.Minute {
+		t.Error("DelayUntilTimeWindow outside window not correct")
+	}
+	if DelayUntilTimeWindow(nil, outside) != 0 {
+		t.Error("DelayUntilTimeWindow without windows not correct")
+	}
+}
@@ -0,0 +1,394 @@
+package web
+
+import (
+	"backup-x/entity"
+	"backup-x/util"
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed artifacts.html
+var artifactsEmbedFile embed.FS
+
+// Locations of a backup file besides the replica target names
+const (
+	locationLocal = "local"
+	locationS3    = "s3"
+)
+
+// presignExpire is how long a pre-signed download URL stays valid
+const presignExpire = 15 * time.Minute
+
+// artifact is a backup file of a project and the places it is stored
+type artifact struct {
+	FileName  string
+	Size      int64
+	ModTime   time.Time
+	Checksum  string
+	Locations []string
+	Hold      *entity.Hold
+}
+
+type artifactsData struct {
+	ProjectName string
+	Projects    []string
+	Artifacts   []*artifact
+	Version     string
+}
+
+// checksumCache holds the checksums of local files, computed in the background, and of objects by their ETag
+var checksumCache = struct {
+	sync.Mutex
+	entries map[string]checksumEntry
+	worker  chan struct{}
+}{entries: map[string]checksumEntry{}, worker: make(chan struct{}, 1)}
+
+type checksumEntry struct {
+	size     int64
+	modTime  time.Time
+	etag     string
+	checksum string
+}
+
+// Artifacts lists the backup files of a project, locally and in object storage
+func Artifacts(writer http.ResponseWriter, request *http.Request) {
+	tmpl, err := template.New("artifacts.html").Funcs(template.FuncMap{
+		"fileSize": func(size int64) string { return util.FormatFileSize(size) },
+	}).ParseFS(artifactsEmbedFile, "artifacts.html")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	conf, _ := entity.GetConfigCache()
+	data := &artifactsData{Version: os.Getenv(VersionEnv)}
+	for _, backupConf := range conf.BackupConfig {
+		if backupConf.NotEmptyProject() {
+			data.Projects = append(data.Projects, backupConf.ProjectName)
+		}
+	}
+
+	data.ProjectName = request.URL.Query().Get("project")
+	if data.ProjectName == "" && len(data.Projects) > 0 {
+		data.ProjectName = data.Projects[0]
+	}
+	if backupConf, ok := findProject(conf, data.ProjectName); ok {
+		data.Artifacts = listArtifacts(conf, backupConf)
+	}
+
+	tmpl.Execute(writer, data)
+}
+
+// ArtifactDownload streams a local backup file, redirects to a pre-signed S3 URL,
+// or streams the object from S3 when the target or the project limits the download bandwidth
+func ArtifactDownload(writer http.ResponseWriter, request *http.Request) {
+	conf, _ := entity.GetConfigCache()
+	backupConf, fileName, location, ok := parseArtifactRequest(conf, request)
+	if !ok {
+		http.Error(writer, "Invalid project or file", http.StatusBadRequest)
+		return
+	}
+
+	if location == locationLocal {
+		file, err := os.Open(filepath.Join(conf.GetProjectPath(backupConf), fileName))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Disposition", "attachment; filename=\""+fileName+"\"")
+		http.ServeContent(writer, request, fileName, info.ModTime(), file)
+		return
+	}
+
+	target, ok := getTarget(conf, location)
+	if !ok {
+		http.Error(writer, "Unknown location", http.StatusBadRequest)
+		return
+	}
+	target = target.ForProject(backupConf)
+	key := backupConf.GetS3Prefix() + fileName
+	if !target.HasDownloadLimit() {
+		url, err := target.PresignDownloadURL(key, presignExpire)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(writer, request, url, http.StatusFound)
+		return
+	}
+
+	// A limited download is streamed through backup-x, the browser would bypass the limit with a pre-signed URL
+	writer.Header().Set("Content-Disposition", "attachment; filename=\""+fileName+"\"")
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	n, err := target.DownloadFile(key, writer)
+	if err != nil {
+		if n == 0 {
+			writer.Header().Del("Content-Disposition")
+			http.Error(writer, err.Error(), http.StatusNotFound)
+		}
+		log.Printf("Failed to download %s from %s, ERR: %s\n", fileName, location, err)
+	}
+}
+
+// ArtifactDelete deletes a backup file from one location
+func ArtifactDelete(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	conf, _ := entity.GetConfigCache()
+	backupConf, fileName, location, ok := parseArtifactRequest(conf, request)
+	if !ok {
+		writer.Write([]byte("Invalid project or file"))
+		return
+	}
+
+	var err error
+	if location == locationLocal {
+		err = os.Remove(filepath.Join(conf.GetProjectPath(backupConf), fileName))
+	} else if target, ok := getTarget(conf, location); ok {
+		err = target.DeleteFile(backupConf.GetS3Prefix() + fileName)
+	} else {
+		writer.Write([]byte("Unknown location"))
+		return
+	}
+
+	if err != nil {
+		log.Printf("Failed to delete %s of project %s from %s, ERR: %s\n", fileName, backupConf.ProjectName, location, err)
+		writer.Write([]byte(err.Error()))
+		return
+	}
+	log.Printf("%s of project %s was deleted manually from %s\n", fileName, backupConf.ProjectName, location)
+	writer.Write([]byte("ok"))
+}
+
+// ArtifactHold places a hold on a backup file so retention skips it, or releases the hold
+func ArtifactHold(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	conf, _ := entity.GetConfigCache()
+	backupConf, fileName, _, ok := parseArtifactRequest(conf, request)
+	if !ok {
+		writer.Write([]byte("Invalid project or file"))
+		return
+	}
+
+	if err := setHold(request, conf, backupConf, fileName, request.FormValue("hold") == "true"); err != nil {
+		writer.Write([]byte(err.Error()))
+		return
+	}
+	writer.Write([]byte("ok"))
+}
+
+// listArtifacts merges the local files and the objects of every S3 target by file name
+func listArtifacts(conf entity.Config, backupConf entity.BackupConfig) []*artifact {
+	artifacts := make(map[string]*artifact)
+	get := func(fileName string) *artifact {
+		if artifacts[fileName] == nil {
+			artifacts[fileName] = &artifact{FileName: fileName}
+			if hold, held := entity.GetHold(backupConf.ProjectName, fileName); held {
+				artifacts[fileName].Hold = &hold
+			}
+		}
+		return artifacts[fileName]
+	}
+
+	files, err := os.ReadDir(conf.GetProjectPath(backupConf))
+	if err != nil {
+		log.Printf("Failed to read local directory for project %s! ERR: %s\n", backupConf.ProjectName, err)
+	}
+	for _, file := range files {
+		info, err := file.Info()
+		if file.IsDir() || err != nil || !isArtifactName(file.Name()) || entity.IsHoldMarker(file.Name()) {
+			continue
+		}
+		a := get(file.Name())
+		a.Size = info.Size()
+		a.ModTime = info.ModTime()
+		a.Checksum = getLocalChecksum(filepath.Join(conf.GetProjectPath(backupConf), file.Name()), info)
+		a.Locations = append(a.Locations, locationLocal)
+	}
+
+	for _, location := range getTargetNames(conf) {
+		target, ok := getTarget(conf, location)
+		if !ok || !target.CheckNotEmpty() {
+			continue
+		}
+		objects, err := target.ListFiles(backupConf.GetS3Prefix())
+		if err != nil {
+			log.Printf("Failed to read %s directory for project %s! ERR: %s\n", location, backupConf.ProjectName, err)
+			continue
+		}
+		for _, object := range objects {
+			if entity.IsHoldMarker(object.Key) {
+				a := get(path.Base(strings.TrimSuffix(object.Key, entity.HoldMarkerSuffix)))
+				if a.Hold == nil {
+					a.Hold = &entity.Hold{ProjectName: backupConf.ProjectName, FileName: a.FileName, Reason: "Marker in " + location, Marker: true}
+				}
+				continue
+			}
+			a := get(path.Base(object.Key))
+			if a.ModTime.IsZero() {
+				a.Size = object.Size
+				a.ModTime = object.LastModified
+			}
+			if a.Checksum == "" && location == locationS3 {
+				a.Checksum = getS3Checksum(target, object)
+			}
+			a.Locations = append(a.Locations, location)
+		}
+	}
+
+	result := make([]*artifact, 0, len(artifacts))
+	for _, a := range artifacts {
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ModTime.After(result[j].ModTime) })
+	return result
+}
+
+// setHold places or releases a hold, the owner defaults to the logged in user.
+// Releasing also removes the sidecar markers of the file in every S3 target
+func setHold(request *http.Request, conf entity.Config, backupConf entity.BackupConfig, fileName string, held bool) error {
+	projectName := backupConf.ProjectName
+	if !held {
+		for _, location := range getTargetNames(conf) {
+			target, _ := getTarget(conf, location)
+			if !target.CheckNotEmpty() {
+				continue
+			}
+			if err := target.DeleteFile(backupConf.GetS3Prefix() + fileName + entity.HoldMarkerSuffix); err != nil {
+				return err
+			}
+		}
+		log.Printf("Hold on %s of project %s was released\n", fileName, projectName)
+		return entity.ReleaseHold(projectName, fileName)
+	}
+
+	owner := request.FormValue("owner")
+	if owner == "" {
+		owner, _, _ = request.BasicAuth()
+	}
+	log.Printf("%s of project %s was held by %s\n", fileName, projectName, owner)
+	return entity.PlaceHold(entity.Hold{
+		ProjectName: projectName,
+		FileName:    fileName,
+		Reason:      request.FormValue("reason"),
+		Owner:       owner,
+	})
+}
+
+// getLocalChecksum returns the cached checksum of a local file, computing it in the background when missing
+func getLocalChecksum(filePath string, info os.FileInfo) string {
+	checksumCache.Lock()
+	defer checksumCache.Unlock()
+
+	entry, ok := checksumCache.entries[filePath]
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.checksum
+	}
+
+	checksumCache.entries[filePath] = checksumEntry{size: info.Size(), modTime: info.ModTime()}
+	go func() {
+		checksumCache.worker <- struct{}{}
+		defer func() { <-checksumCache.worker }()
+
+		checksum, err := util.FileSha256(filePath)
+		if err != nil {
+			return
+		}
+		checksumCache.Lock()
+		checksumCache.entries[filePath] = checksumEntry{size: info.Size(), modTime: info.ModTime(), checksum: checksum}
+		checksumCache.Unlock()
+	}()
+	return ""
+}
+
+// getS3Checksum returns the checksum in the metadata of an object, cached until its ETag changes
+func getS3Checksum(target entity.S3Config, object entity.S3Object) string {
+	cacheKey := target.Endpoint + "/" + target.BucketName + "/" + object.Key
+	checksumCache.Lock()
+	entry, ok := checksumCache.entries[cacheKey]
+	checksumCache.Unlock()
+	if ok && entry.etag == object.ETag {
+		return entry.checksum
+	}
+
+	checksum, err := target.GetChecksum(object.Key)
+	if err != nil {
+		return ""
+	}
+	checksumCache.Lock()
+	checksumCache.entries[cacheKey] = checksumEntry{etag: object.ETag, checksum: checksum}
+	checksumCache.Unlock()
+	return checksum
+}
+
+// parseArtifactRequest reads and validates the project, file and location of a request
+func parseArtifactRequest(conf entity.Config, request *http.Request) (backupConf entity.BackupConfig, fileName string, location string, ok bool) {
+	backupConf, ok = findProject(conf, request.FormValue("project"))
+	fileName = request.FormValue("file")
+	location = request.FormValue("location")
+	if location == "" {
+		location = locationLocal
+	}
+	return backupConf, fileName, location, ok && isArtifactName(fileName)
+}
+
+// isArtifactName checks that a file name is a plain backup file name within the project directory
+func isArtifactName(fileName string) bool {
+	return fileName != "" && fileName != "." && fileName != ".." &&
+		filepath.Base(fileName) == fileName && path.Base(fileName) == fileName &&
+		!strings.HasPrefix(fileName, "shell-")
+}
+
+// findProject returns the project with the given name
+func findProject(conf entity.Config, projectName string) (entity.BackupConfig, bool) {
+	for _, backupConf := range conf.BackupConfig {
+		if backupConf.ProjectName != "" && backupConf.ProjectName == projectName {
+			return backupConf, true
+		}
+	}
+	return entity.BackupConfig{}, false
+}
+
+// getTargetNames returns the locations of the primary S3 config and the replica targets
+func getTargetNames(conf entity.Config) []string {
+	targets := []string{locationS3}
+	for _, replica := range conf.Replicas {
+		targets = append(targets, replica.Name)
+	}
+	return targets
+}
+
+// getTarget returns the primary S3 config or the replica target with the given name
+func getTarget(conf entity.Config, location string) (entity.S3Config, bool) {
+	if location == locationS3 {
+		return conf.S3Config, true
+	}
+	for _, replica := range conf.Replicas {
+		if replica.Name == location {
+			return replica.S3Config, true
+		}
+	}
+	return entity.S3Config{}, false
+}
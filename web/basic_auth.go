@@ -7,6 +7,7 @@ import (
 	"encoding/base64"
 	"log"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 )
@@ -23,7 +24,13 @@ var ld = &loginDetect{}
 // BasicAuth basic auth
 func BasicAuth(f ViewFunc) ViewFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		conf, _ := entity.GetConfigCache()
+		conf, err := entity.GetConfigCache()
+		// Only a missing config file allows the first setup, a config that cannot be read or decrypted never logs in
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("%s login rejected, the configuration could not be loaded: %s\n", r.RemoteAddr, err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 
 	
 		if conf.Username == "" && conf.Password == "" {
@@ -55,8 +62,10 @@ func BasicAuth(f ViewFunc) ViewFunc {
 			)
 			if err == nil {
 				pair := bytes.SplitN(payload, []byte(":"), 2)
-				pwd, _ := util.DecryptByEncryptKey(conf.EncryptKey, conf.Password)
-				if len(pair) == 2 &&
+				pwd, err := util.DecryptByEncryptKey(conf.EncryptKey, conf.Password)
+				if err != nil {
+					log.Printf("%s login rejected, the password could not be decrypted: %s\n", r.RemoteAddr, err)
+				} else if len(pair) == 2 &&
 					bytes.Equal(pair[0], []byte(conf.Username)) &&
 					bytes.Equal(pair[1], []byte(pwd)) {
 					ld.FailTimes = 0
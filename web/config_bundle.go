@@ -0,0 +1,67 @@
+package web
+
+import (
+	"backup-x/client"
+	"backup-x/entity"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// maxBundleSize limits the size of an uploaded bundle
+const maxBundleSize = 10 << 20
+
+// ExportConfig downloads the configuration as a bundle with its secrets encrypted with the posted passphrase
+func ExportConfig(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	conf, err := entity.GetConfigCache()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bundle, err := conf.Export(request.FormValue("Passphrase"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/x-yaml")
+	writer.Header().Set("Content-Disposition", "attachment; filename=\"backup-x-config-"+time.Now().Format("2006-01-02")+".yaml\"")
+	writer.Write(bundle)
+}
+
+// ImportConfig imports an uploaded bundle, merging or replacing the projects, and restarts the backup loops
+func ImportConfig(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	request.Body = http.MaxBytesReader(writer, request.Body, maxBundleSize)
+	file, _, err := request.FormFile("Bundle")
+	if err != nil {
+		writer.Write([]byte("Please choose a bundle file"))
+		return
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		writer.Write([]byte(err.Error()))
+		return
+	}
+
+	conf, err := entity.ImportBundle(data, request.FormValue("Passphrase"), request.FormValue("Mode"))
+	if err != nil {
+		writer.Write([]byte(err.Error()))
+		return
+	}
+
+	conf.CreateBucketIfNotExist()
+	client.StopRunLoop()
+	go client.RunLoop(100 * time.Millisecond)
+	writer.Write([]byte("ok"))
+}
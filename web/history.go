@@ -0,0 +1,59 @@
+package web
+
+import (
+	"backup-x/client"
+	"backup-x/entity"
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+//go:embed history.html
+var historyEmbedFile embed.FS
+
+type historyData struct {
+	Revisions []entity.ConfigRevision
+	Version   string
+}
+
+// History lists the previous configurations and what changed in each of them
+func History(writer http.ResponseWriter, request *http.Request) {
+	tmpl, err := template.ParseFS(historyEmbedFile, "history.html")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	revisions, err := entity.GetConfigHistory()
+	if err != nil {
+		log.Println(err)
+	}
+	err = tmpl.Execute(writer, &historyData{Revisions: revisions, Version: os.Getenv(VersionEnv)})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Rollback saves a previous configuration again and restarts the backup loops
+func Rollback(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	author, _, _ := request.BasicAuth()
+	conf, err := entity.RollbackConfig(request.FormValue("id"), author)
+	if err != nil {
+		writer.Write([]byte(err.Error()))
+		return
+	}
+	log.Printf("Configuration was rolled back to %s by %s\n", request.FormValue("id"), author)
+
+	conf.CreateBucketIfNotExist()
+	client.StopRunLoop()
+	go client.RunLoop(100 * time.Millisecond)
+	writer.Write([]byte("ok"))
+}
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"backup-x/entity"
+	"encoding/json"
+	"net/http"
+)
+
+// Holds is the JSON API for holds: GET lists them, POST places one and DELETE releases one.
+// POST and DELETE take the project and file, POST also takes the reason and owner
+func Holds(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+
+	if request.Method == http.MethodGet {
+		holds, err := entity.GetHolds()
+		if err != nil {
+			writeJSONError(writer, http.StatusInternalServerError, err.Error())
+			return
+		}
+		json.NewEncoder(writer).Encode(holds)
+		return
+	}
+
+	if request.Method != http.MethodPost && request.Method != http.MethodDelete {
+		writeJSONError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	conf, _ := entity.GetConfigCache()
+	backupConf, fileName, _, ok := parseArtifactRequest(conf, request)
+	if !ok {
+		writeJSONError(writer, http.StatusBadRequest, "Invalid project or file")
+		return
+	}
+	if err := setHold(request, conf, backupConf, fileName, request.Method == http.MethodPost); err != nil {
+		writeJSONError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if request.Method == http.MethodDelete {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	hold, _ := entity.GetHold(backupConf.ProjectName, fileName)
+	json.NewEncoder(writer).Encode(hold)
+}
+
+// writeJSONError writes an error response of the JSON API
+func writeJSONError(writer http.ResponseWriter, status int, message string) {
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(map[string]string{"error": message})
+}
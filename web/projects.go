@@ -0,0 +1,140 @@
+package web
+
+import (
+	"backup-x/client"
+	"backup-x/entity"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Projects is the JSON API listing the projects selected by id, group, tag and text, all projects without a selection.
+// Passwords are not returned
+func Projects(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	if request.Method != http.MethodGet {
+		writeJSONError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	conf, _ := entity.GetConfigCache()
+	projects := conf.SelectProjects(parseProjectSelector(request))
+	for i := range projects {
+		projects[i].Pwd = ""
+	}
+	if projects == nil {
+		projects = []entity.BackupConfig{}
+	}
+	json.NewEncoder(writer).Encode(projects)
+}
+
+// ProjectsBulk applies an action to the projects selected by id, group, tag and text.
+// The actions are enable, disable, run and update, which changes saveDays, saveDaysS3 and storageTarget if given
+func ProjectsBulk(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	if request.Method != http.MethodPost {
+		writeJSONError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	selector := parseProjectSelector(request)
+	if selector.Empty() {
+		writeJSONError(writer, http.StatusBadRequest, "No projects selected")
+		return
+	}
+	author, _, _ := request.BasicAuth()
+
+	var update entity.BulkUpdate
+	switch request.FormValue("action") {
+	case "run":
+		conf, _ := entity.GetConfigCache()
+		var ids []string
+		for _, backupConf := range conf.SelectProjects(selector) {
+			ids = append(ids, backupConf.ID)
+		}
+		if len(ids) == 0 {
+			writeJSONError(writer, http.StatusBadRequest, "No projects match the selection")
+			return
+		}
+		log.Printf("%d projects are run now by %s\n", len(ids), author)
+		go func() {
+			for _, id := range ids {
+				client.RunByID(id)
+			}
+		}()
+		writer.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(writer).Encode(map[string][]string{"projects": ids})
+		return
+	case "enable":
+		enabled := 0
+		update.Enabled = &enabled
+	case "disable":
+		disabled := 1
+		update.Enabled = &disabled
+	case "update":
+		var ok bool
+		if update.SaveDays, ok = parseOptionalInt(request, "saveDays"); !ok {
+			writeJSONError(writer, http.StatusBadRequest, "Invalid saveDays")
+			return
+		}
+		if update.SaveDaysS3, ok = parseOptionalInt(request, "saveDaysS3"); !ok {
+			writeJSONError(writer, http.StatusBadRequest, "Invalid saveDaysS3")
+			return
+		}
+		if _, ok := request.Form["storageTarget"]; ok {
+			storageTarget := strings.TrimSpace(request.FormValue("storageTarget"))
+			update.StorageTarget = &storageTarget
+		}
+	default:
+		writeJSONError(writer, http.StatusBadRequest, "Unknown action")
+		return
+	}
+
+	ids, err := entity.BulkUpdateProjects(selector, update, author)
+	if _, invalid := err.(entity.ValidationErrors); invalid {
+		writeJSONError(writer, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("%d projects were changed by %s: %s\n", len(ids), author, request.FormValue("action"))
+
+	client.StopRunLoop()
+	go client.RunLoop(100 * time.Millisecond)
+	json.NewEncoder(writer).Encode(map[string][]string{"projects": ids})
+}
+
+// parseProjectSelector reads the ids, group, tags and search text of a request
+func parseProjectSelector(request *http.Request) entity.ProjectSelector {
+	request.ParseForm()
+	selector := entity.ProjectSelector{
+		Group: strings.TrimSpace(request.Form.Get("group")),
+		Text:  strings.TrimSpace(request.Form.Get("text")),
+	}
+	for _, id := range request.Form["id"] {
+		if id = strings.TrimSpace(id); id != "" {
+			selector.IDs = append(selector.IDs, id)
+		}
+	}
+	for _, tag := range request.Form["tag"] {
+		selector.Tags = append(selector.Tags, entity.ParseTags(tag)...)
+	}
+	return selector
+}
+
+// parseOptionalInt reads a whole number of a request, nil if it is not given
+func parseOptionalInt(request *http.Request, key string) (*int, bool) {
+	if _, ok := request.Form[key]; !ok {
+		return nil, true
+	}
+	number, err := strconv.Atoi(strings.TrimSpace(request.Form.Get(key)))
+	if err != nil {
+		return nil, false
+	}
+	return &number, true
+}
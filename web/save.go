@@ -4,9 +4,11 @@ import (
 	"backup-x/client"
 	"backup-x/entity"
 	"backup-x/util"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -20,6 +22,11 @@ var saveLimit = time.Duration(30 * time.Minute)
 
 
 func Save(writer http.ResponseWriter, request *http.Request) {
+	if entity.DeclarativeConfigFile() != "" {
+		writer.Write([]byte("The configuration is read-only, change " + entity.DeclarativeConfigFile() + " and restart backup-x"))
+		return
+	}
+
 	oldConf, _ := entity.GetConfigCache()
 	conf := &entity.Config{}
 
@@ -44,11 +51,15 @@ func Save(writer http.ResponseWriter, request *http.Request) {
 	conf.Username = strings.TrimSpace(request.FormValue("Username"))
 	conf.Password = request.FormValue("Password")
 
-	if conf.Username == "" || conf.Password == "" {
-		writer.Write([]byte("Please enter login username/password"))
-		return
+	// Invalid values are collected and shown next to their fields
+	var errs entity.ValidationErrors
+	if conf.Username == "" {
+		errs.Add("Username", -1, "Please enter login username")
+	}
+	if conf.Password == "" {
+		errs.Add("Password", -1, "Please enter login password")
 	}
-	if conf.Password != oldConf.Password {
+	if conf.Password != "" && conf.Password != oldConf.Password {
 		encryptPasswd, err := util.EncryptByEncryptKey(conf.EncryptKey, conf.Password)
 		if err != nil {
 			writer.Write([]byte("Encryption failed"))
@@ -57,40 +68,66 @@ func Save(writer http.ResponseWriter, request *http.Request) {
 		conf.Password = encryptPasswd
 	}
 
+	// Projects are matched to the saved ones by id, a clone by the id of its source.
+	// Empty projects are skipped, so the form index of each project is kept for its errors
 	forms := request.PostForm
+	var projectIndexes []int
 	for index, projectName := range forms["ProjectName"] {
-		saveDays, _ := strconv.Atoi(forms["SaveDays"][index])
-		saveDaysS3, _ := strconv.Atoi(forms["SaveDaysS3"][index])
-		startTime, _ := strconv.Atoi(forms["StartTime"][index])
-		period, _ := strconv.Atoi(forms["Period"][index])
-		backupType, _ := strconv.Atoi(forms["BackupType"][index])
-		enabled, _ := strconv.Atoi(forms["Enabled"][index])
-		conf.BackupConfig = append(
-			conf.BackupConfig,
-			entity.BackupConfig{
-				ProjectName: projectName,
-				Command:     forms["Command"][index],
-				SaveDays:    saveDays,
-				SaveDaysS3:  saveDaysS3,
-				StartTime:   startTime,
-				Period:      period,
-				Pwd:         forms["Pwd"][index],
-				BackupType:  backupType,
-				Enabled:     enabled,
-			},
-		)
-	}
-
-	for i := 0; i < len(conf.BackupConfig); i++ {
-		if conf.BackupConfig[i].Pwd != "" &&
-			(len(oldConf.BackupConfig) == 0 || conf.BackupConfig[i].Pwd != oldConf.BackupConfig[i].Pwd) {
-			encryptPwd, err := util.EncryptByEncryptKey(conf.EncryptKey, conf.BackupConfig[i].Pwd)
+		saveDays := parseInt(requiredFormValue(forms, "SaveDays", index, &errs), "SaveDays", index, &errs)
+		saveDaysS3 := parseInt(requiredFormValue(forms, "SaveDaysS3", index, &errs), "SaveDaysS3", index, &errs)
+		startTime := parseInt(requiredFormValue(forms, "StartTime", index, &errs), "StartTime", index, &errs)
+		period := parseInt(requiredFormValue(forms, "Period", index, &errs), "Period", index, &errs)
+		backupType := parseInt(requiredFormValue(forms, "BackupType", index, &errs), "BackupType", index, &errs)
+		enabled := parseInt(requiredFormValue(forms, "Enabled", index, &errs), "Enabled", index, &errs)
+		uploadRequired := parseInt(requiredFormValue(forms, "UploadRequired", index, &errs), "UploadRequired", index, &errs)
+		maxUploadKBps := parseInt(formValue(forms, "MaxUploadKBps", index), "MaxUploadKBps", index, &errs)
+		maxDownloadKBps := parseInt(formValue(forms, "MaxDownloadKBps", index), "MaxDownloadKBps", index, &errs)
+		uploadWindows := strings.TrimSpace(formValue(forms, "UploadWindows", index))
+		backupConf := entity.BackupConfig{
+			ProjectName:     strings.TrimSpace(projectName),
+			Group:           strings.TrimSpace(formValue(forms, "Group", index)),
+			Tags:            entity.ParseTags(formValue(forms, "Tags", index)),
+			StorageTarget:   formValue(forms, "StorageTarget", index),
+			OutputDir:       strings.TrimSpace(formValue(forms, "OutputDir", index)),
+			Command:         requiredFormValue(forms, "Command", index, &errs),
+			SaveDays:        saveDays,
+			SaveDaysS3:      saveDaysS3,
+			StartTime:       startTime,
+			Period:          period,
+			Pwd:             formValue(forms, "Pwd", index),
+			BackupType:      backupType,
+			Enabled:         enabled,
+			BackupRetry:     parseRetryPolicy(forms, "BackupRetry", index, &errs),
+			UploadRetry:     parseRetryPolicy(forms, "UploadRetry", index, &errs),
+			UploadRequired:  uploadRequired,
+			MaxUploadKBps:   maxUploadKBps,
+			MaxDownloadKBps: maxDownloadKBps,
+			UploadWindows:   uploadWindows,
+			PreHook:         formValue(forms, "PreHook", index),
+			PostSuccessHook: formValue(forms, "PostSuccessHook", index),
+			PostFailureHook: formValue(forms, "PostFailureHook", index),
+			CleanupHook:     formValue(forms, "CleanupHook", index),
+		}
+		if backupConf.EmptyProject() {
+			continue
+		}
+
+		source, saved := oldConf.GetProjectByID(formValue(forms, "ProjectID", index))
+		if saved {
+			backupConf.ID = source.ID
+		} else {
+			source, _ = oldConf.GetProjectByID(formValue(forms, "CloneOf", index))
+		}
+		if backupConf.Pwd != "" && !entity.IsSecretRef(backupConf.Pwd) && backupConf.Pwd != source.Pwd {
+			encryptPwd, err := util.EncryptByEncryptKey(conf.EncryptKey, backupConf.Pwd)
 			if err != nil {
 				writer.Write([]byte("Encryption failed"))
 				return
 			}
-			conf.BackupConfig[i].Pwd = encryptPwd
+			backupConf.Pwd = encryptPwd
 		}
+		conf.BackupConfig = append(conf.BackupConfig, backupConf)
+		projectIndexes = append(projectIndexes, index)
 	}
 
 	// Webhook
@@ -103,8 +140,25 @@ func Save(writer http.ResponseWriter, request *http.Request) {
 	conf.SecretKey = strings.TrimSpace(request.FormValue("SecretKey"))
 	conf.BucketName = strings.TrimSpace(request.FormValue("BucketName"))
 	conf.Region = strings.TrimSpace(request.FormValue("Region"))
+	conf.PartSize = parseInt(request.FormValue("PartSize"), "PartSize", -1, &errs)
+	conf.Concurrency = parseInt(request.FormValue("Concurrency"), "Concurrency", -1, &errs)
+	conf.S3Config.MaxUploadKBps = parseInt(request.FormValue("S3MaxUploadKBps"), "S3MaxUploadKBps", -1, &errs)
+	conf.S3Config.MaxDownloadKBps = parseInt(request.FormValue("S3MaxDownloadKBps"), "S3MaxDownloadKBps", -1, &errs)
+	conf.S3Config.UploadWindows = strings.TrimSpace(request.FormValue("S3UploadWindows"))
+	conf.ServerSideEncryption = strings.TrimSpace(request.FormValue("ServerSideEncryption"))
+	conf.SSEKMSKeyID = strings.TrimSpace(request.FormValue("SSEKMSKeyID"))
+	conf.StorageClass = strings.TrimSpace(request.FormValue("StorageClass"))
+	conf.ObjectLockMode = strings.TrimSpace(request.FormValue("ObjectLockMode"))
+	conf.CredentialSource = strings.TrimSpace(request.FormValue("CredentialSource"))
+	conf.Profile = strings.TrimSpace(request.FormValue("Profile"))
+	conf.SharedCredentialsFile = strings.TrimSpace(request.FormValue("SharedCredentialsFile"))
+	conf.WebIdentityTokenFile = strings.TrimSpace(request.FormValue("WebIdentityTokenFile"))
+	conf.RoleARN = strings.TrimSpace(request.FormValue("RoleARN"))
+	conf.ExternalID = strings.TrimSpace(request.FormValue("ExternalID"))
+	conf.RoleSessionName = strings.TrimSpace(request.FormValue("RoleSessionName"))
+	conf.STSEndpoint = strings.TrimSpace(request.FormValue("STSEndpoint"))
 
-	if conf.SecretKey != "" && conf.SecretKey != oldConf.SecretKey {
+	if conf.SecretKey != "" && conf.SecretKey != oldConf.SecretKey && !entity.IsSecretRef(conf.SecretKey) {
 		secretKey, err := util.EncryptByEncryptKey(conf.EncryptKey, conf.SecretKey)
 		if err != nil {
 			writer.Write([]byte("Encryption failed"))
@@ -113,8 +167,121 @@ func Save(writer http.ResponseWriter, request *http.Request) {
 		conf.SecretKey = secretKey
 	}
 
-	
-	err := conf.SaveConfig()
+	// Replica targets, keeping settings only available in the config file.
+	// Empty rows are skipped, so the form index of each target is kept for its errors
+	var replicaIndexes, secretIndexes []int
+	for index, name := range forms["ReplicaName"] {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		replica := entity.ReplicaTarget{}
+		for _, oldReplica := range oldConf.Replicas {
+			if oldReplica.Name == name {
+				replica = oldReplica
+			}
+		}
+		replica.Name = name
+		replica.SaveDays = parseInt(formValue(forms, "ReplicaSaveDays", index), "ReplicaSaveDays", index, &errs)
+		replica.Endpoint = strings.TrimSpace(formValue(forms, "ReplicaEndpoint", index))
+		replica.AccessKey = strings.TrimSpace(formValue(forms, "ReplicaAccessKey", index))
+		replica.BucketName = strings.TrimSpace(formValue(forms, "ReplicaBucketName", index))
+		replica.Region = strings.TrimSpace(formValue(forms, "ReplicaRegion", index))
+		secretKey := strings.TrimSpace(formValue(forms, "ReplicaSecretKey", index))
+		if secretKey != "" && secretKey != replica.SecretKey && !entity.IsSecretRef(secretKey) {
+			encryptSecretKey, err := util.EncryptByEncryptKey(conf.EncryptKey, secretKey)
+			if err != nil {
+				writer.Write([]byte("Encryption failed"))
+				return
+			}
+			secretKey = encryptSecretKey
+		}
+		replica.SecretKey = secretKey
+		conf.Replicas = append(conf.Replicas, replica)
+		replicaIndexes = append(replicaIndexes, index)
+	}
+
+	// Secrets are write-only, an empty value keeps the saved one
+	for index, name := range forms["SecretName"] {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		secret := entity.Secret{Name: name}
+		for _, oldSecret := range oldConf.Secrets {
+			if oldSecret.Name == name {
+				secret.Value = oldSecret.Value
+			}
+		}
+		if value := formValue(forms, "SecretValue", index); entity.IsSecretRef(value) {
+			secret.Value = value
+		} else if value != "" {
+			encryptValue, err := util.EncryptByEncryptKey(conf.EncryptKey, value)
+			if err != nil {
+				writer.Write([]byte("Encryption failed"))
+				return
+			}
+			secret.Value = encryptValue
+		}
+		conf.Secrets = append(conf.Secrets, secret)
+		secretIndexes = append(secretIndexes, index)
+	}
+
+	conf.BackupRoot = strings.TrimSpace(request.FormValue("BackupRoot"))
+
+	// Templates are changed with the templates API
+	conf.Templates = oldConf.Templates
+
+	// Vault for vault: references
+	conf.Vault.Address = strings.TrimSpace(request.FormValue("VaultAddress"))
+	conf.Vault.Namespace = strings.TrimSpace(request.FormValue("VaultNamespace"))
+	conf.Vault.RoleID = strings.TrimSpace(request.FormValue("VaultRoleID"))
+	conf.Vault.Token = strings.TrimSpace(request.FormValue("VaultToken"))
+	conf.Vault.SecretID = strings.TrimSpace(request.FormValue("VaultSecretID"))
+	if conf.Vault.Token != "" && conf.Vault.Token != oldConf.Vault.Token && !entity.IsSecretRef(conf.Vault.Token) {
+		token, err := util.EncryptByEncryptKey(conf.EncryptKey, conf.Vault.Token)
+		if err != nil {
+			writer.Write([]byte("Encryption failed"))
+			return
+		}
+		conf.Vault.Token = token
+	}
+	if conf.Vault.SecretID != "" && conf.Vault.SecretID != oldConf.Vault.SecretID && !entity.IsSecretRef(conf.Vault.SecretID) {
+		secretID, err := util.EncryptByEncryptKey(conf.EncryptKey, conf.Vault.SecretID)
+		if err != nil {
+			writer.Write([]byte("Encryption failed"))
+			return
+		}
+		conf.Vault.SecretID = secretID
+	}
+
+	// Errors refer to the projects and rows in the form,
+	// fields that could not be parsed keep only that error
+	parseErrs := len(errs)
+	for _, fieldErr := range conf.Validate() {
+		switch {
+		case fieldErr.Index < 0:
+		case strings.HasPrefix(fieldErr.Field, "Replica"):
+			fieldErr.Index = replicaIndexes[fieldErr.Index]
+		case strings.HasPrefix(fieldErr.Field, "Secret"):
+			fieldErr.Index = secretIndexes[fieldErr.Index]
+		case strings.HasPrefix(fieldErr.Field, "Template"):
+		default:
+			fieldErr.Index = projectIndexes[fieldErr.Index]
+		}
+		if !hasFieldError(errs[:parseErrs], fieldErr) {
+			errs = append(errs, fieldErr)
+		}
+	}
+	if len(errs) > 0 {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(writer).Encode(map[string]entity.ValidationErrors{"errors": errs})
+		return
+	}
+
+	author, _, _ := request.BasicAuth()
+	err := conf.SaveConfigBy(author, "")
 
 	
 	if err == nil {
@@ -122,10 +289,15 @@ func Save(writer http.ResponseWriter, request *http.Request) {
 		if request.URL.Query().Get("backupAll") == "true" {
 			go client.RunOnce()
 		}
+		// backupIdx is the position of the selected project in the form, it has an id once saved
 		if request.URL.Query().Get("backupIdx") != "" {
 			idx, err := strconv.Atoi(request.URL.Query().Get("backupIdx"))
 			if err == nil {
-				go client.RunByIdx(idx)
+				for i, index := range projectIndexes {
+					if index == idx {
+						go client.RunByID(conf.BackupConfig[i].ID)
+					}
+				}
 			} else {
 				log.Println("Index number is incorrect" + request.URL.Query().Get("backupIdx"))
 			}
@@ -143,3 +315,66 @@ func Save(writer http.ResponseWriter, request *http.Request) {
 	}
 
 }
+
+// parseRetryPolicy reads the retry policy fields with the given prefix for a project
+func parseRetryPolicy(forms url.Values, prefix string, index int, errs *entity.ValidationErrors) entity.RetryPolicy {
+	return entity.RetryPolicy{
+		MaxAttempts:   parseInt(formValue(forms, prefix+"MaxAttempts", index), prefix+"MaxAttempts", index, errs),
+		InitialDelay:  parseInt(formValue(forms, prefix+"InitialDelay", index), prefix+"InitialDelay", index, errs),
+		BackoffFactor: parseFloat(formValue(forms, prefix+"BackoffFactor", index), prefix+"BackoffFactor", index, errs),
+		Jitter:        parseFloat(formValue(forms, prefix+"Jitter", index), prefix+"Jitter", index, errs),
+	}
+}
+
+// formValue returns a field of the project or row at index, empty when the form does not have it
+func formValue(forms url.Values, key string, index int) string {
+	if index < len(forms[key]) {
+		return forms[key][index]
+	}
+	return ""
+}
+
+// requiredFormValue returns a field the form always sends for a project, a missing field is an error of the field
+func requiredFormValue(forms url.Values, key string, index int, errs *entity.ValidationErrors) string {
+	if index >= len(forms[key]) {
+		errs.Add(key, index, "The form has no %s for this project", key)
+		return ""
+	}
+	return forms[key][index]
+}
+
+// hasFieldError checks whether errs has an error of the same field
+func hasFieldError(errs entity.ValidationErrors, fieldErr entity.FieldError) bool {
+	for _, err := range errs {
+		if err.Field == fieldErr.Field && err.Index == fieldErr.Index {
+			return true
+		}
+	}
+	return false
+}
+
+// parseInt reads a whole number of a form field, an empty value is 0
+func parseInt(value string, field string, index int, errs *entity.ValidationErrors) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		errs.Add(field, index, "%s is not a whole number", value)
+	}
+	return number
+}
+
+// parseFloat reads a number of a form field, an empty value is 0
+func parseFloat(value string, field string, index int, errs *entity.ValidationErrors) float64 {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		errs.Add(field, index, "%s is not a number", value)
+	}
+	return number
+}
@@ -0,0 +1,128 @@
+package web
+
+import (
+	"backup-x/entity"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// templateInfo is a project template with the names of the parameters it uses
+type templateInfo struct {
+	entity.ProjectTemplate
+	Params []string
+}
+
+// getTemplateInfos returns the built-in and user-defined templates with their parameters
+func getTemplateInfos(conf entity.Config) []templateInfo {
+	templates := conf.GetTemplates()
+	infos := make([]templateInfo, len(templates))
+	for i, tmpl := range templates {
+		infos[i] = templateInfo{ProjectTemplate: tmpl, Params: tmpl.Params()}
+		if infos[i].Params == nil {
+			infos[i].Params = []string{}
+		}
+	}
+	return infos
+}
+
+// Templates is the JSON API of the project templates. GET lists the built-in and user-defined templates,
+// POST saves a user-defined template, or deletes it with the action delete
+func Templates(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	switch request.Method {
+	case http.MethodGet:
+		conf, _ := entity.GetConfigCache()
+		json.NewEncoder(writer).Encode(getTemplateInfos(conf))
+		return
+	case http.MethodPost:
+	default:
+		writeJSONError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	author, _, _ := request.BasicAuth()
+	name := strings.TrimSpace(request.FormValue("name"))
+	if request.FormValue("action") == "delete" {
+		if err := entity.DeleteTemplate(name, author); err != nil {
+			writeJSONError(writer, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("Template %s was deleted by %s\n", name, author)
+		conf, _ := entity.GetConfigCache()
+		json.NewEncoder(writer).Encode(getTemplateInfos(conf))
+		return
+	}
+
+	tmpl := entity.ProjectTemplate{
+		Name:        name,
+		Description: strings.TrimSpace(request.FormValue("description")),
+		Command:     strings.TrimSpace(request.FormValue("command")),
+		Defaults:    parseTemplateParams(request),
+	}
+	for key, value := range map[string]*int{"backupType": &tmpl.BackupType, "saveDays": &tmpl.SaveDays, "saveDaysS3": &tmpl.SaveDaysS3} {
+		number, ok := parseOptionalInt(request, key)
+		if !ok {
+			writeJSONError(writer, http.StatusBadRequest, "Invalid "+key)
+			return
+		}
+		if number != nil {
+			*value = *number
+		}
+	}
+
+	err := entity.SaveTemplate(tmpl, author)
+	if _, invalid := err.(entity.ValidationErrors); invalid {
+		writeJSONError(writer, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("Template %s was saved by %s\n", name, author)
+	conf, _ := entity.GetConfigCache()
+	json.NewEncoder(writer).Encode(getTemplateInfos(conf))
+}
+
+// TemplateRender returns the backup script and retention generated by a template from the parameters of a request
+func TemplateRender(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	if request.Method != http.MethodPost {
+		writeJSONError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	conf, _ := entity.GetConfigCache()
+	tmpl, ok := conf.GetTemplate(strings.TrimSpace(request.FormValue("name")))
+	if !ok {
+		writeJSONError(writer, http.StatusNotFound, "Template not found")
+		return
+	}
+	project, err := tmpl.Render(parseTemplateParams(request))
+	if err != nil {
+		writeJSONError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	json.NewEncoder(writer).Encode(map[string]string{
+		"Command":    project.Command,
+		"BackupType": strconv.Itoa(project.BackupType),
+		"SaveDays":   strconv.Itoa(project.SaveDays),
+		"SaveDaysS3": strconv.Itoa(project.SaveDaysS3),
+	})
+}
+
+// parseTemplateParams reads the template parameters of a request
+func parseTemplateParams(request *http.Request) entity.TemplateParams {
+	request.ParseForm()
+	return entity.TemplateParams{
+		Host:     strings.TrimSpace(request.Form.Get("host")),
+		Port:     strings.TrimSpace(request.Form.Get("port")),
+		User:     strings.TrimSpace(request.Form.Get("user")),
+		Database: strings.TrimSpace(request.Form.Get("database")),
+		Path:     strings.TrimSpace(request.Form.Get("path")),
+		Remote:   strings.TrimSpace(request.Form.Get("remote")),
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 )
 
 
@@ -13,7 +14,9 @@ func WebhookTest(writer http.ResponseWriter, request *http.Request) {
 	requestBody := strings.TrimSpace(request.FormValue("RequestBody"))
 	if url != "" {
 		wb := entity.Webhook{WebhookURL: url, WebhookRequestBody: requestBody}
-		wb.ExecWebhook(entity.BackupResult{ProjectName: "Simulation test", FileName: "2021-11-11_01_01.sql", FileSize: "100 MB", Result: "Success"})
+		wb.ExecWebhook(entity.BackupResult{ProjectName: "Simulation test", FileName: "2021-11-11_01_01.sql", FileSize: "100 MB", Result: "Success",
+			Upload: entity.UploadResult{Result: "Success", Bytes: 100 * 1000 * 1000, Duration: 10 * time.Second, ETag: "d41d8cd98f00b204e9800998ecf8427e"},
+			Hooks: []entity.HookResult{{Hook: entity.HookPre, Result: "Success"}, {Hook: entity.HookCleanup, Result: "Success"}}})
 	} else {
 		log.Println("Please enter the Webhook URL")
 	}
@@ -3,10 +3,12 @@ package web
 import (
 	"backup-x/entity"
 	"embed"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 )
 
 //go:embed writing.html
@@ -16,12 +18,37 @@ const VersionEnv = "BACKUP_X_VERSION"
 
 type writtingData struct {
 	entity.Config
-	Version string
+	NewProject       entity.BackupConfig
+	Tags             []string
+	Groups           []string
+	ReplicaTargets   []string       // Names of the replica targets a project can use as storage target
+	ProjectTemplates []templateInfo // Built-in and user-defined templates of new projects
+	ConfigFile       string         // Declarative config file the read-only configuration is loaded from
+	Version          string
+}
+
+// projectPane is a project in the settings form, Key makes the ids of its fields unique
+type projectPane struct {
+	Key            string
+	Project        entity.BackupConfig
+	Active         bool
+	ReplicaTargets []string
+}
+
+// newProject returns the defaults of a new project
+func newProject() entity.BackupConfig {
+	return entity.BackupConfig{SaveDays: 30, SaveDaysS3: 60, StartTime: 1, Period: 1440, BackupType: 0,
+		BackupRetry: entity.DefaultRetryPolicy, UploadRetry: entity.DefaultRetryPolicy}
 }
 
 
 func WritingConfig(writer http.ResponseWriter, request *http.Request) {
-	tmpl, err := template.ParseFS(writingEmbedFile, "writing.html")
+	tmpl, err := template.New("writing.html").Funcs(template.FuncMap{
+		"pane": func(key interface{}, project entity.BackupConfig, replicaTargets []string) projectPane {
+			return projectPane{Key: fmt.Sprint(key), Project: project, Active: fmt.Sprint(key) == "0", ReplicaTargets: replicaTargets}
+		},
+		"join": strings.Join,
+	}).ParseFS(writingEmbedFile, "writing.html")
 	if err != nil {
 		log.Println(err)
 		return
@@ -29,19 +56,33 @@ func WritingConfig(writer http.ResponseWriter, request *http.Request) {
 
 	conf, err := entity.GetConfigCache()
 	if err == nil {
-		tmpl.Execute(writer, &writtingData{Config: conf, Version: os.Getenv(VersionEnv)})
+		data := &writtingData{NewProject: newProject(), ProjectTemplates: getTemplateInfos(conf), ConfigFile: entity.DeclarativeConfigFile(), Version: os.Getenv(VersionEnv)}
+		data.Tags, data.Groups = conf.GetTags()
+		for _, replica := range conf.Replicas {
+			data.ReplicaTargets = append(data.ReplicaTargets, replica.Name)
+		}
+		// An empty row to add a replica target
+		conf.Replicas = append(conf.Replicas, entity.ReplicaTarget{})
+		// Secret values are write-only, with an empty row to add a secret
+		secrets := make([]entity.Secret, 0, len(conf.Secrets)+1)
+		for _, secret := range conf.Secrets {
+			secrets = append(secrets, entity.Secret{Name: secret.Name})
+		}
+		conf.Secrets = append(secrets, entity.Secret{})
+		if len(conf.BackupConfig) == 0 {
+			conf.BackupConfig = []entity.BackupConfig{newProject()}
+		}
+		data.Config = conf
+		tmpl.Execute(writer, data)
 		return
 	}
 
 	// default config
-	
-	backupConf := []entity.BackupConfig{}
-	for i := 0; i < 16; i++ {
-		backupConf = append(backupConf, entity.BackupConfig{SaveDays: 30, SaveDaysS3: 60, StartTime: 1, Period: 1440, BackupType: 0})
-	}
 	conf = entity.Config{
-		BackupConfig: backupConf,
+		BackupConfig: []entity.BackupConfig{newProject()},
+		Replicas:     []entity.ReplicaTarget{{}},
+		Secrets:      []entity.Secret{{}},
 	}
 
-	tmpl.Execute(writer, &writtingData{Config: conf, Version: os.Getenv(VersionEnv)})
+	tmpl.Execute(writer, &writtingData{Config: conf, NewProject: newProject(), ProjectTemplates: getTemplateInfos(conf), Version: os.Getenv(VersionEnv)})
 }